@@ -0,0 +1,83 @@
+package main
+
+import (
+    "flag"
+    "strings"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+)
+
+// flagOptions are the settings that can still be given as flags. A flag
+// that is given overrides the config file and the environment.
+type flagOptions struct {
+    interval     int
+    listen       string
+    display      bool
+    panel        string
+    rotation     int
+    pageInterval time.Duration
+    pages        string
+    source       string
+    metarStation string
+    temperature  string
+    humidity     string
+    rainfall     string
+    language     string
+}
+
+func (f *flagOptions) register(defaults config.Config) {
+    flag.IntVar(&f.interval, "interval", int(defaults.Sensors.HTU31D.Interval/time.Second), "sensor read interval in seconds, for every sensor")
+    flag.StringVar(&f.listen, "listen", defaults.Metrics.Listen, "listen address for prometheus metrics")
+    flag.BoolVar(&f.display, "display", defaults.Display.Enabled, "show readings on an Inky Impression e-paper display")
+    flag.StringVar(&f.panel, "panel", defaults.Display.Panel, "e-paper panel size: 4, 5.7 or 7.3")
+    flag.IntVar(&f.rotation, "rotate", defaults.Display.Rotation, "e-paper rotation in degrees: 0, 90, 180 or 270")
+    flag.DurationVar(&f.pageInterval, "page-interval", defaults.Display.PageInterval, "switch e-paper pages on this interval, 0 to only switch with the buttons")
+    flag.StringVar(&f.pages, "pages", strings.Join(defaults.Display.Pages, ","), "e-paper pages in button order: overview, air, trends, forecast, warnings and sensors")
+    flag.StringVar(&f.source, "weather", defaults.Weather.Source, "outdoor weather source: hko, metar or none")
+    flag.StringVar(&f.metarStation, "metar-station", defaults.Weather.METAR.Station, "ICAO code of the airport to get METAR reports for")
+    flag.StringVar(&f.temperature, "hko-temperature", defaults.Weather.HKO.TemperatureStation, "HKO station for outdoor temperature, by key or name")
+    flag.StringVar(&f.humidity, "hko-humidity", defaults.Weather.HKO.HumidityStation, "HKO station for outdoor humidity, by key or name")
+    flag.StringVar(&f.rainfall, "hko-rainfall", defaults.Weather.HKO.RainfallDistrict, "district for HKO rainfall, by key or name")
+    flag.StringVar(&f.language, "hko-lang", defaults.Weather.HKO.Language, "language of HKO forecasts and place names: en, tc or sc")
+}
+
+// apply copies the flags that were given into cfg.
+func (f *flagOptions) apply(cfg *config.Config) {
+    flag.Visit(func(given *flag.Flag) {
+        switch given.Name {
+        case "interval":
+            interval := time.Duration(f.interval) * time.Second
+            cfg.Sensors.HTU31D.Interval = interval
+            cfg.Sensors.PMSA003I.Interval = interval
+            cfg.Sensors.DPS310.Interval = interval
+        case "listen":
+            cfg.Metrics.Listen = f.listen
+        case "display":
+            cfg.Display.Enabled = f.display
+        case "panel":
+            cfg.Display.Panel = f.panel
+        case "rotate":
+            cfg.Display.Rotation = f.rotation
+        case "page-interval":
+            cfg.Display.PageInterval = f.pageInterval
+        case "pages":
+            cfg.Display.Pages = nil
+            for _, page := range strings.Split(f.pages, ",") {
+                cfg.Display.Pages = append(cfg.Display.Pages, strings.TrimSpace(page))
+            }
+        case "weather":
+            cfg.Weather.Source = f.source
+        case "metar-station":
+            cfg.Weather.METAR.Station = f.metarStation
+        case "hko-temperature":
+            cfg.Weather.HKO.TemperatureStation = f.temperature
+        case "hko-humidity":
+            cfg.Weather.HKO.HumidityStation = f.humidity
+        case "hko-rainfall":
+            cfg.Weather.HKO.RainfallDistrict = f.rainfall
+        case "hko-lang":
+            cfg.Weather.HKO.Language = f.language
+        }
+    })
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "periph.io/x/conn/v3/physic"
+    "periph.io/x/conn/v3/spi"
+    "periph.io/x/conn/v3/spi/spireg"
+
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/metrics"
+    "github.com/tony-tsang/airmon/internal/pkg/screen"
+    "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+    "github.com/tony-tsang/airmon/internal/pkg/widget"
+)
+
+// displayState collects readings for the e-paper pages. The main loop
+// updates it and the screen manager takes a copy before each refresh.
+type displayState struct {
+    mu      sync.Mutex
+    data    screen.Data
+    sensors map[string]screen.SensorStatus
+}
+
+func newDisplayState(readings *history.Store) *displayState {
+    return &displayState{
+        data:    screen.Data{History: readings},
+        sensors: make(map[string]screen.SensorStatus),
+    }
+}
+
+// update changes the data under the lock and marks sensor as just seen.
+// change is nil for sensors only shown on the sensors page.
+func (s *displayState) update(sensor string, detail string, change func(data *screen.Data)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if change != nil {
+        change(&s.data)
+    }
+    s.sensors[sensor] = screen.SensorStatus{Name: sensor, LastSeen: time.Now(), Detail: detail}
+}
+
+func (s *displayState) snapshot() screen.Data {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    data := s.data
+    data.Now = time.Now()
+    data.Sensors = make([]screen.SensorStatus, 0, len(s.sensors))
+    for _, sensor := range s.sensors {
+        data.Sensors = append(data.Sensors, sensor)
+    }
+    sort.Slice(data.Sensors, func(i, j int) bool { return data.Sensors[i].Name < data.Sensors[j].Name })
+
+    return data
+}
+
+// displayController owns the e-paper display. apply starts, stops or changes
+// it to match the config, setting the panel up again only when the panel
+// itself changes.
+type displayController struct {
+    state *displayState
+
+    spiBus  spi.PortCloser
+    conn    spi.Conn
+    fonts   *widget.Fonts
+    presses chan int
+    buttons bool
+
+    cfg     config.Display
+    manager *screen.Manager
+
+    // only used by the manager's OnRefresh
+    panelUnreadable bool
+    panelSource     string
+}
+
+func newDisplayController(state *displayState) *displayController {
+    return &displayController{state: state, presses: make(chan int, 4)}
+}
+
+// apply makes the display match cfg. Errors are returned only for bad
+// options or a missing SPI bus, and leave the display as it was; a missing
+// or broken panel is logged on every refresh and doesn't stop the daemon.
+func (c *displayController) apply(cfg config.Display, spiName string) error {
+
+    if !cfg.Enabled {
+        c.stop()
+        c.cfg = cfg
+        return nil
+    }
+
+    if c.fonts == nil {
+        fonts, err := widget.DefaultFonts()
+        if err != nil {
+            return err
+        }
+        c.fonts = fonts
+    }
+
+    pages, err := screen.PagesByName(c.fonts, cfg.Pages)
+    if err != nil {
+        return err
+    }
+
+    if c.manager != nil && samePanel(c.cfg, cfg) {
+        c.manager.SetLayout(pages, cfg.PageInterval)
+        c.cfg = cfg
+        return nil
+    }
+
+    panel, ok := cfg.PanelConfig()
+    if !ok {
+        return errors.New("unknown panel " + cfg.Panel)
+    }
+
+    if c.conn == nil {
+        c.spiBus, err = spireg.Open(spiName)
+        if err != nil {
+            return fmt.Errorf("failed to open SPI: %w", err)
+        }
+
+        c.conn, err = c.spiBus.Connect(physic.MegaHertz, spi.Mode3|spi.NoCS, 8)
+        if err != nil {
+            c.spiBus.Close()
+            c.spiBus = nil
+            c.conn = nil
+            return fmt.Errorf("failed to connect to SPI: %w", err)
+        }
+    }
+
+    d := new(uc8159.Display)
+    err = d.InitConfig(c.conn, panel)
+    if err != nil {
+        return err
+    }
+
+    // nothing after this fails, so a bad change leaves the old panel running
+    c.stop()
+
+    // a new panel, or one no longer read, starts without the old readings
+    metrics.DisplayPanelTemperature.Reset()
+    metrics.DisplayStatus.Reset()
+    c.panelSource, c.panelUnreadable = "", false
+
+    manager := screen.NewManager(d, c.fonts, pages)
+    manager.Rotate = cfg.PageInterval
+    manager.OnRefresh = func(page screen.Page, err error) {
+        switch {
+        case errors.Is(err, uc8159.ErrTemperatureRange):
+            log.Printf("Deferring refresh of %s, ambient temperature out of range", page.Name())
+            metrics.DisplayDeferredRefreshes.Inc()
+        case errors.Is(err, uc8159.ErrBusyTimeout):
+            log.Printf("Refresh of %s timed out", page.Name())
+            metrics.DisplayBusyTimeouts.Inc()
+        case err != nil:
+            log.Printf("Refresh of %s failed: %v", page.Name(), err)
+        }
+        if cfg.ReadPanel {
+            c.publishPanel(d)
+        }
+    }
+
+    // the buttons can't be let go of, so they are only watched once
+    if !c.buttons {
+        c.buttons = true
+        err = screen.WatchButtons(cfg.Buttons, c.presses)
+        if err != nil {
+            log.Printf("Buttons disabled: %v", err)
+        }
+    }
+
+    go manager.Run(c.state.snapshot, c.presses)
+
+    c.manager = manager
+    c.cfg = cfg
+    return nil
+}
+
+// publishPanel reads the controller's temperature and status after a
+// refresh, for boards with MISO wired. A read that fails, or that comes back
+// as the all zero or all one bits of an unwired line, is only logged once and
+// leaves the last readings in place.
+func (c *displayController) publishPanel(d *uc8159.Display) {
+    celsius, err := d.PanelTemperature()
+    if errors.Is(err, uc8159.ErrNotInitialised) {
+        return
+    }
+    if err != nil {
+        if !c.panelUnreadable {
+            c.panelUnreadable = true
+            log.Printf("Unable to read the e-paper controller: %v", err)
+        }
+        return
+    }
+
+    // the old source's series is dropped when the ambient temperature
+    // starts or stops being forced
+    source := d.TemperatureSource()
+    if source != c.panelSource {
+        metrics.DisplayPanelTemperature.Reset()
+        c.panelSource = source
+    }
+    metrics.DisplayPanelTemperature.WithLabelValues(source).Set(celsius)
+
+    status, err := d.Status()
+    if err != nil {
+        return
+    }
+    for flag, set := range status.Flags() {
+        value := 0.0
+        if set {
+            value = 1
+        }
+        metrics.DisplayStatus.WithLabelValues(flag).Set(value)
+    }
+}
+
+// stop stops the screen manager, if it is running.
+func (c *displayController) stop() {
+    if c.manager != nil {
+        c.manager.Stop()
+        c.manager = nil
+    }
+}
+
+// samePanel reports whether a and b drive the panel the same way, so only
+// the pages need changing.
+func samePanel(a config.Display, b config.Display) bool {
+    return a.Panel == b.Panel && a.Rotation == b.Rotation && a.Pins == b.Pins && a.ReadPanel == b.ReadPanel
+}
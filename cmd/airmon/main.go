@@ -2,89 +2,253 @@ package main
 
 import (
     "flag"
-    "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "fmt"
     "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
     "time"
 
     "periph.io/x/conn/v3/driver/driverreg"
-    "periph.io/x/conn/v3/i2c/i2creg"
-    "periph.io/x/conn/v3/physic"
-    "periph.io/x/conn/v3/spi"
-    "periph.io/x/conn/v3/spi/spireg"
     "periph.io/x/host/v3"
 
-    "github.com/tony-tsang/airmon/internal/pkg/htu31"
+    "github.com/tony-tsang/airmon/internal/pkg/api"
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/i2cbus"
     "github.com/tony-tsang/airmon/internal/pkg/metrics"
-    "github.com/tony-tsang/airmon/internal/pkg/pmsa003i"
+    "github.com/tony-tsang/airmon/internal/pkg/screen"
+    "github.com/tony-tsang/airmon/internal/pkg/weather"
 )
 
 func main() {
 
-    _, err := host.Init()
+    if len(os.Args) > 1 && os.Args[1] == "scan" {
+        runScan(os.Args[2:])
+        return
+    }
+
+    var configPath string
+    var checkConfig bool
+    var listPlaces bool
+    var flags flagOptions
+
+    flag.StringVar(&configPath, "config", os.Getenv(config.ENV_CONFIG), "YAML config file, settings given as flags override it")
+    flag.BoolVar(&checkConfig, "check-config", false, "check the config file and exit")
+    flag.BoolVar(&listPlaces, "hko-places", false, "list the HKO stations and districts and exit")
+    flags.register(config.Default())
+    flag.Parse()
+
+    cfg, err := config.Load(configPath)
     if err != nil {
-        log.Fatalf("failed to initialize periph: %v", err)
+        log.Fatalf("invalid config:\n%v", err)
+    }
+    flags.apply(&cfg)
+    err = cfg.Validate()
+    if err != nil {
+        log.Fatalf("invalid options:\n%v", err)
     }
 
-    _, err = driverreg.Init()
+    if checkConfig {
+        fmt.Println("config OK")
+        return
+    }
+
+    if listPlaces {
+        printPlaces(cfg.Weather.HKO)
+        return
+    }
+
+    _, err = host.Init()
     if err != nil {
         log.Fatalf("failed to initialize periph: %v", err)
     }
 
-    spiBus, err := spireg.Open("")
+    _, err = driverreg.Init()
     if err != nil {
-        log.Fatalf("failed to open SPI: %v", err)
+        log.Fatalf("failed to initialize periph: %v", err)
     }
 
-    i2cBus, err := i2creg.Open("")
+    i2cBus, err := i2cbus.Open(cfg.Buses.I2C)
     if err != nil {
         log.Fatalf("failed to open I2C: %v", err)
     }
-
-    defer spiBus.Close()
     defer i2cBus.Close()
+    i2cBus.OnTx = recordTx
 
-    _, err = spiBus.Connect(physic.MegaHertz, spi.Mode3, 8)
+    sensors := newSensorRunner(i2cBus, cfg.Buses)
+    sensors.apply(cfg.Sensors)
 
-    var sleepInterval int
-    var listenAddress string
+    readings := history.NewStore(cfg.Storage.Retention)
+    if cfg.Storage.Path != "" {
+        err = readings.Load(cfg.Storage.Path)
+        if err != nil {
+            log.Printf("Unable to load history from %s: %v", cfg.Storage.Path, err)
+        }
+    }
 
-    flag.IntVar(&sleepInterval, "interval", 10, "sensor read interval in seconds")
-    flag.StringVar(&listenAddress, "listen", ":8080", "listen address for prometheus metrics")
-    flag.Parse()
+    state := newDisplayState(readings)
+
+    running := &subsystems{
+        configPath:     configPath,
+        flags:          &flags,
+        cfg:            cfg,
+        sensors:        sensors,
+        readings:       readings,
+        display:        newDisplayController(state),
+        weatherReports: make(chan weather.Report),
+    }
+
+    err = running.startWeather(cfg.Weather)
+    if err != nil {
+        log.Fatalf("invalid weather options: %v", err)
+    }
 
-    sleepDuration := time.Duration(sleepInterval) * time.Second
+    running.startStorage(cfg.Storage)
 
-    tempHumidityChannel := make(chan htu31.TempHumidity)
-    go htu31.DoLoop(i2cBus, tempHumidityChannel, sleepDuration)
+    err = running.display.apply(cfg.Display, cfg.Buses.SPI)
+    if err != nil {
+        log.Fatalf("failed to start display: %v", err)
+    }
+
+    // reloads asked for through the API are done by the main loop, like
+    // those from SIGHUP, so they don't race with the readings
+    type reloadReply struct {
+        result api.ReloadResult
+        err    error
+    }
+    reloadRequests := make(chan chan reloadReply)
+
+    apiServer := api.NewServer()
+    apiServer.ReloadToken = cfg.API.ReloadToken
+    apiServer.Reload = func() (api.ReloadResult, error) {
+        reply := make(chan reloadReply, 1)
+        reloadRequests <- reply
+        r := <-reply
+        return r.result, r.err
+    }
+
+    if cfg.API.Listen == "" || cfg.API.Listen == cfg.Metrics.Listen {
+        apiServer.Register(http.DefaultServeMux)
+    } else {
+        mux := http.NewServeMux()
+        apiServer.Register(mux)
+        go func() {
+            log.Fatal("Error listening socket, ", http.ListenAndServe(cfg.API.Listen, mux))
+        }()
+    }
 
-    pmValueChannel := make(chan pmsa003i.PMSensorValue)
-    go pmsa003i.DoLoop(i2cBus, pmValueChannel, sleepDuration)
+    go metrics.StartServer(cfg.Metrics.Listen)
 
-    pressureSensorChannel := make(chan dps310.TempPressure)
-    go dps310.DoLoop(i2cBus, pressureSensorChannel, sleepDuration)
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-    go metrics.StartServer(listenAddress)
+    hangup := make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
 
     for {
         select {
-        case tempHumidity := <-tempHumidityChannel:
-            log.Printf("Temperature %.2f, humidity %.2f\n", tempHumidity.Temp, tempHumidity.Humidity)
-            metrics.TemperatureMetric.Set(tempHumidity.Temp)
-            metrics.HumidityMetric.Set(tempHumidity.Humidity)
-
-        case pmValue := <-pmValueChannel:
-            log.Printf("PM1.0 %d PM2.5 %d PM10 %d", pmValue.PM10std, pmValue.PM25std, pmValue.PM100std)
-            metrics.PM10StdMetric.Set(float64(pmValue.PM10std))
-            metrics.PM25StdMetric.Set(float64(pmValue.PM25std))
-            metrics.PM100StdMetric.Set(float64(pmValue.PM100std))
-            metrics.PM10Concentration.Set(float64(pmValue.PM10env))
-            metrics.PM25Concentration.Set(float64(pmValue.PM25env))
-            metrics.PM100Concentration.Set(float64(pmValue.PM100env))
-        case pressureValue := <-pressureSensorChannel:
-            log.Printf("Presssure %0.2g Temperature %0.2g", pressureValue.Pressure, pressureValue.Temp)
-            metrics.PressureMetric.Set(pressureValue.Pressure)
-        default:
-            time.Sleep(1 * time.Second)
+        case r := <-sensors.tempHumidity:
+            tempHumidity := r.value
+            log.Printf("%s temperature %.2f, humidity %.2f\n", r.sensor.Name, tempHumidity.Temp, tempHumidity.Humidity)
+            metrics.TemperatureMetric.WithLabelValues(r.sensor.Name, r.sensor.Location).Set(tempHumidity.Temp)
+            metrics.HumidityMetric.WithLabelValues(r.sensor.Name, r.sensor.Location).Set(tempHumidity.Humidity)
+
+            detail := fmt.Sprintf("%.2f℃ %.1f%%", tempHumidity.Temp, tempHumidity.Humidity)
+            if !r.sensor.Primary {
+                state.update(r.sensor.Name, detail, nil)
+                break
+            }
+
+            now := time.Now()
+            readings.Add(history.Temperature, now, tempHumidity.Temp)
+            readings.Add(history.Humidity, now, tempHumidity.Humidity)
+            state.update(r.sensor.Name, detail, func(data *screen.Data) {
+                data.Weather.IndoorData.Temperature = tempHumidity.Temp
+                data.Weather.IndoorData.Humidity = tempHumidity.Humidity
+                data.Ambient = tempHumidity.Temp
+                data.HasAmbient = true
+            })
+
+        case r := <-sensors.pm:
+            pmValue := r.value
+            log.Printf("%s PM1.0 %d PM2.5 %d PM10 %d", r.sensor.Name, pmValue.PM10std, pmValue.PM25std, pmValue.PM100std)
+            labels := []string{r.sensor.Name, r.sensor.Location}
+            metrics.PM10StdMetric.WithLabelValues(labels...).Set(float64(pmValue.PM10std))
+            metrics.PM25StdMetric.WithLabelValues(labels...).Set(float64(pmValue.PM25std))
+            metrics.PM100StdMetric.WithLabelValues(labels...).Set(float64(pmValue.PM100std))
+            metrics.PM10Concentration.WithLabelValues(labels...).Set(float64(pmValue.PM10env))
+            metrics.PM25Concentration.WithLabelValues(labels...).Set(float64(pmValue.PM25env))
+            metrics.PM100Concentration.WithLabelValues(labels...).Set(float64(pmValue.PM100env))
+
+            detail := fmt.Sprintf("PM2.5 %d", pmValue.PM25env)
+            if !r.sensor.Primary {
+                state.update(r.sensor.Name, detail, nil)
+                break
+            }
+
+            readings.Add(history.PM25, time.Now(), float64(pmValue.PM25env))
+            state.update(r.sensor.Name, detail, func(data *screen.Data) {
+                data.Weather.PM10 = float64(pmValue.PM10env)
+                data.Weather.PM25 = float64(pmValue.PM25env)
+                data.Weather.PM100 = float64(pmValue.PM100env)
+            })
+        case r := <-sensors.pressure:
+            pressureValue := r.value
+            log.Printf("%s presssure %0.2g temperature %0.2g", r.sensor.Name, pressureValue.Pressure, pressureValue.Temp)
+            metrics.PressureMetric.WithLabelValues(r.sensor.Name, r.sensor.Location).Set(pressureValue.Pressure)
+
+            detail := fmt.Sprintf("%.1f hPa", pressureValue.Pressure)
+            if !r.sensor.Primary {
+                state.update(r.sensor.Name, detail, nil)
+                break
+            }
+
+            readings.Add(history.Pressure, time.Now(), pressureValue.Pressure)
+            state.update(r.sensor.Name, detail, func(data *screen.Data) {
+                data.Weather.IndoorData.Pressure = pressureValue.Pressure
+            })
+        case report := <-running.weatherReports:
+            log.Printf("Outdoor temperature %.1f, humidity %.0f from %s", report.Temperature.Value, report.Humidity.Value, report.Source)
+
+            detail := fmt.Sprintf("%.1f℃ %.0f%%", report.Temperature.Value, report.Humidity.Value)
+            if report.Stale {
+                detail += " from " + report.Fetched.Format("15:04")
+            } else {
+                if report.Temperature.Valid() {
+                    readings.Add(history.OutdoorTemperature, report.Fetched, report.Temperature.Value)
+                }
+                if report.Humidity.Valid() {
+                    readings.Add(history.OutdoorHumidity, report.Fetched, report.Humidity.Value)
+                }
+                recordRainfall(readings, report)
+            }
+
+            state.update(strings.ToUpper(report.Source), detail, func(data *screen.Data) {
+                applyReport(&data.Weather, report)
+            })
+            apiServer.SetWeather(report)
+            recordWeatherMetrics(report)
+        case <-hangup:
+            logReload(running.reload())
+
+        case reply := <-reloadRequests:
+            result, err := running.reload()
+            logReload(result, err)
+            reply <- reloadReply{result, err}
+
+        case sig := <-stop:
+            log.Printf("Stopping on %v", sig)
+            sensors.close()
+            if path := running.cfg.Storage.Path; path != "" {
+                err = readings.Save(path)
+                if err != nil {
+                    log.Printf("Unable to save history to %s: %v", path, err)
+                }
+            }
+            return
         }
     }
 }
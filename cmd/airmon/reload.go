@@ -0,0 +1,181 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/api"
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/weather"
+)
+
+// restartOnly are the settings that can't be changed while running: the
+// buses are in use, the listeners can't be moved without dropping scrapes,
+// and the buttons can't be let go of.
+var restartOnly = []string{"buses", "metrics", "api", "display.buttons"}
+
+// subsystems are the parts of airmon a reload can restart on their own.
+type subsystems struct {
+    configPath string
+    flags      *flagOptions
+    cfg        config.Config
+
+    sensors        *sensorRunner
+    readings       *history.Store
+    display        *displayController
+    weatherReports chan weather.Report
+
+    stopWeather context.CancelFunc
+    stopStorage context.CancelFunc
+}
+
+// startWeather replaces the weather loop with one for cfg. Reports go to
+// s.weatherReports.
+func (s *subsystems) startWeather(cfg config.Weather) error {
+    provider, err := newWeatherProvider(cfg)
+    if err != nil {
+        return err
+    }
+
+    s.runWeather(cfg, provider)
+    return nil
+}
+
+// runWeather replaces the weather loop with one fetching from provider, which
+// is nil for none.
+func (s *subsystems) runWeather(cfg config.Weather, provider weather.Provider) {
+    if s.stopWeather != nil {
+        s.stopWeather()
+        s.stopWeather = nil
+    }
+
+    if provider != nil {
+        var ctx context.Context
+        ctx, s.stopWeather = context.WithCancel(context.Background())
+        go weather.DoLoop(ctx, provider, s.weatherReports, cfg.Interval)
+    }
+}
+
+// startStorage replaces the loop saving the history with one for cfg.
+func (s *subsystems) startStorage(cfg config.Storage) {
+    if s.stopStorage != nil {
+        s.stopStorage()
+        s.stopStorage = nil
+    }
+
+    s.readings.SetRetention(cfg.Retention)
+
+    if cfg.Path != "" {
+        var ctx context.Context
+        ctx, s.stopStorage = context.WithCancel(context.Background())
+        go saveHistory(ctx, s.readings, cfg.Path, cfg.SaveInterval)
+    }
+}
+
+// saveHistory saves the readings to path every interval, so a crash loses at
+// most one interval.
+func saveHistory(ctx context.Context, readings *history.Store, path string, interval time.Duration) {
+    for {
+        select {
+        case <-time.After(interval):
+        case <-ctx.Done():
+            return
+        }
+
+        err := readings.Save(path)
+        if err != nil {
+            log.Printf("Unable to save history to %s: %v", path, err)
+        }
+    }
+}
+
+// reload reads the config again and restarts only the subsystems whose
+// settings changed. Settings that need airmon restarting are reported and
+// left as they are, so they are reported again by the next reload.
+// Everything that can fail is done before anything is changed, so a failed
+// reload leaves airmon running on the old config.
+func (s *subsystems) reload() (api.ReloadResult, error) {
+    cfg, err := config.Load(s.configPath)
+    if err != nil {
+        return api.ReloadResult{}, err
+    }
+    s.flags.apply(&cfg)
+    err = cfg.Validate()
+    if err != nil {
+        return api.ReloadResult{}, err
+    }
+
+    changed := s.cfg.Diff(&cfg)
+    result := api.ReloadResult{Changed: changed}
+
+    for _, key := range changed {
+        if config.Changed([]string{key}, restartOnly...) {
+            result.Restart = append(result.Restart, key)
+        }
+    }
+
+    cfg.Buses = s.cfg.Buses
+    cfg.Metrics = s.cfg.Metrics
+    cfg.API = s.cfg.API
+    cfg.Display.Buttons = s.cfg.Display.Buttons
+
+    var provider weather.Provider
+    if config.Changed(changed, "weather") {
+        provider, err = newWeatherProvider(cfg.Weather)
+        if err != nil {
+            return result, fmt.Errorf("weather, nothing reloaded: %w", err)
+        }
+    }
+
+    // the panel can still fail to open, so it goes before the rest
+    if config.Changed(changed, "display") {
+        err = s.display.apply(cfg.Display, cfg.Buses.SPI)
+        if err != nil {
+            return result, fmt.Errorf("display, nothing reloaded: %w", err)
+        }
+        result.Applied = append(result.Applied, "display")
+    }
+
+    // detecting again picks up boards plugged in since
+    if cfg.Sensors.Detect || config.Changed(changed, "sensors") {
+        s.sensors.apply(cfg.Sensors)
+        result.Applied = append(result.Applied, "sensors")
+    }
+
+    if config.Changed(changed, "weather") {
+        s.runWeather(cfg.Weather, provider)
+        result.Applied = append(result.Applied, "weather")
+    }
+
+    if config.Changed(changed, "storage") {
+        s.startStorage(cfg.Storage)
+        result.Applied = append(result.Applied, "storage")
+    }
+
+    s.cfg = cfg
+    return result, nil
+}
+
+// logReload logs what a reload did.
+func logReload(result api.ReloadResult, err error) {
+    switch {
+    case err != nil:
+        log.Printf("Reload failed: %v", err)
+    case len(result.Changed) == 0:
+        log.Printf("Reloaded, nothing changed")
+    default:
+        restarted := "nothing"
+        if len(result.Applied) > 0 {
+            restarted = strings.Join(result.Applied, ", ")
+        }
+        log.Printf("Reloaded, changed %s, restarted %s", strings.Join(result.Changed, ", "), restarted)
+    }
+
+    if len(result.Restart) > 0 {
+        log.Printf("Restart airmon to change %s", strings.Join(result.Restart, ", "))
+    }
+}
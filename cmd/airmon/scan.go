@@ -0,0 +1,103 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "text/tabwriter"
+
+    "periph.io/x/conn/v3/driver/driverreg"
+    "periph.io/x/conn/v3/i2c"
+    "periph.io/x/host/v3"
+
+    "github.com/tony-tsang/airmon/internal/pkg/detect"
+    "github.com/tony-tsang/airmon/internal/pkg/i2cbus"
+    "github.com/tony-tsang/airmon/internal/pkg/tca9548a"
+)
+
+// runScan is airmon scan: it probes the I2C bus for the known sensors and
+// prints what it found, to check the wiring before writing a config.
+func runScan(args []string) {
+    scanFlags := flag.NewFlagSet("scan", flag.ExitOnError)
+    busName := scanFlags.String("bus", "", "periph I2C bus name, empty for the first bus found")
+    all := scanFlags.Bool("all", false, "also list every address that answers, known or not")
+    muxAddr := scanFlags.String("mux", "", "address of a TCA9548A mux, such as 0x70, to scan each of its channels too")
+    scanFlags.Usage = func() {
+        fmt.Fprintf(scanFlags.Output(), "Usage: %s scan [flags]\n", os.Args[0])
+        scanFlags.PrintDefaults()
+    }
+    scanFlags.Parse(args)
+
+    _, err := host.Init()
+    if err != nil {
+        log.Fatalf("failed to initialize periph: %v", err)
+    }
+
+    _, err = driverreg.Init()
+    if err != nil {
+        log.Fatalf("failed to initialize periph: %v", err)
+    }
+
+    bus, err := i2cbus.Open(*busName)
+    if errors.Is(err, i2cbus.ErrBusInUse) {
+        log.Fatalf("%v\nStop airmon to scan the bus", err)
+    }
+    if err != nil {
+        log.Fatalf("failed to open I2C: %v", err)
+    }
+    defer bus.Close()
+
+    buses := []i2c.Bus{bus}
+    if *muxAddr != "" {
+        addr, err := strconv.ParseUint(*muxAddr, 0, 16)
+        if err != nil {
+            log.Fatalf("invalid mux address %q: %v", *muxAddr, err)
+        }
+        mux := tca9548a.NewAt(bus, uint16(addr))
+        // so the bus itself is scanned without a channel left connected
+        err = mux.Select(-1)
+        if err != nil {
+            log.Fatalf("no mux at 0x%02x: %v", addr, err)
+        }
+        for n := 0; n < tca9548a.CHANNELS; n++ {
+            channel, _ := mux.Channel(n)
+            buses = append(buses, channel)
+        }
+    }
+
+    for _, b := range buses {
+        scanBus(b, *all)
+    }
+
+    fmt.Println("With sensors.detect and sensors.auto_enable on, as they are by default, the")
+    fmt.Println("daemon does the same at start and reload: sensors found on their configured")
+    fmt.Println("bus are turned on there and those not found are turned off, logging each.")
+}
+
+// scanBus prints what detect finds on bus.
+func scanBus(bus i2c.Bus, all bool) {
+    fmt.Printf("Scanning %s\n\n", bus)
+
+    results := detect.Scan(bus)
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "SENSOR\tADDRESS\tRESULT")
+    for _, r := range results {
+        result := "found, " + r.Detail
+        if !r.Found() {
+            result = "not found: " + r.Err.Error()
+        }
+        fmt.Fprintf(w, "%s\t0x%02x\t%s\n", r.Sensor, r.Addr, result)
+    }
+    w.Flush()
+
+    if all {
+        fmt.Println("\nAddresses answering:")
+        for _, addr := range detect.Responding(bus) {
+            fmt.Printf("  0x%02x\n", addr)
+        }
+    }
+    fmt.Println()
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "periph.io/x/conn/v3/i2c"
+
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+    "github.com/tony-tsang/airmon/internal/pkg/detect"
+    "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "github.com/tony-tsang/airmon/internal/pkg/health"
+    "github.com/tony-tsang/airmon/internal/pkg/htu31"
+    "github.com/tony-tsang/airmon/internal/pkg/i2cbus"
+    "github.com/tony-tsang/airmon/internal/pkg/metrics"
+    "github.com/tony-tsang/airmon/internal/pkg/pmsa003i"
+    "github.com/tony-tsang/airmon/internal/pkg/schedule"
+    "github.com/tony-tsang/airmon/internal/pkg/tca9548a"
+)
+
+// readingBuffer is how many readings of each type can wait for the main
+// loop, enough for every sensor of a type to finish at once.
+const readingBuffer = 16
+
+// reading is a value from one sensor, with the sensor it came from.
+type reading[T any] struct {
+    sensor config.Instance
+    value  T
+}
+
+// sensorRunner reads the sensors on the scheduler and sends the readings to
+// the main loop. A sensor is only set up again when it is added or moved to
+// another bus or address, so changing an interval leaves the others
+// untouched.
+type sensorRunner struct {
+    // the bus named by buses.i2c, and any others sensors are on, including
+    // mux channels, opened as they are needed
+    main     *sensorBus
+    cfgBuses config.Buses
+    buses    map[string]*sensorBus
+    muxes    map[string]*tca9548a.Mux
+
+    scheduler *schedule.Scheduler
+    running   map[string]*runningSensor
+
+    tempHumidity chan reading[htu31.TempHumidity]
+    pm           chan reading[pmsa003i.PMSensorValue]
+    pressure     chan reading[dps310.TempPressure]
+}
+
+// sensorBus is a bus sensors can be on: an I2C bus, or a channel of a mux on
+// one. A channel's transactions are queued and reported by the bus the mux
+// is on, as the sensor's own.
+type sensorBus struct {
+    bus     *i2cbus.Bus
+    channel *tca9548a.Channel
+}
+
+// device returns the bus as seen by the driver called name.
+func (b *sensorBus) device(name string, priority i2cbus.Priority) i2c.Bus {
+    device := b.bus.Device(name, priority, 0)
+    if b.channel == nil {
+        return device
+    }
+    return b.channel.On(device)
+}
+
+func (b *sensorBus) String() string {
+    if b.channel != nil {
+        return b.channel.String()
+    }
+    return b.bus.String()
+}
+
+// runningSensor is a sensor that has been set up. Its tracker is only used
+// by its job.
+type runningSensor struct {
+    cfg     config.Instance
+    bus     *sensorBus
+    tracker *health.Tracker
+
+    lead    time.Duration
+    prepare func()
+    // read takes a reading and, if it worked, sends it to the main loop
+    read func(sensor config.Instance) error
+    // reset is nil for sensors that can't be reset
+    reset func() error
+}
+
+func newSensorRunner(bus *i2cbus.Bus, buses config.Buses) *sensorRunner {
+    scheduler := schedule.New()
+    scheduler.OnRun = recordRun
+
+    return &sensorRunner{
+        main:         &sensorBus{bus: bus},
+        cfgBuses:     buses,
+        buses:        make(map[string]*sensorBus),
+        muxes:        make(map[string]*tca9548a.Mux),
+        scheduler:    scheduler,
+        running:      make(map[string]*runningSensor),
+        tempHumidity: make(chan reading[htu31.TempHumidity], readingBuffer),
+        pm:           make(chan reading[pmsa003i.PMSensorValue], readingBuffer),
+        pressure:     make(chan reading[dps310.TempPressure], readingBuffer),
+    }
+}
+
+// apply makes the running sensors match cfg, after detecting them if
+// cfg.Detect is set.
+func (r *sensorRunner) apply(cfg config.Sensors) {
+    // set the muxes up first, so no channel is left connected while the
+    // buses they are on are scanned
+    for _, mux := range r.cfgBuses.Muxes {
+        _, _, err := r.muxFor(mux.Name)
+        if err != nil {
+            log.Printf("Unable to set up mux %s: %v", mux.Name, err)
+        }
+    }
+
+    instances := cfg.Instances()
+    if cfg.Detect {
+        var disabled []config.Instance
+        if cfg.AutoEnable {
+            disabled = cfg.Disabled()
+        }
+        instances = r.detect(instances, disabled)
+    }
+
+    wanted := make(map[string]config.Instance)
+    for _, sensor := range instances {
+        wanted[sensor.Name] = sensor
+    }
+    for name, s := range r.running {
+        if sensor, ok := wanted[name]; !ok || !samePlace(s.cfg, sensor) {
+            r.stop(name)
+        }
+    }
+
+    for _, sensor := range instances {
+        // each job reads its own sensor
+        sensor := sensor
+        s, ok := r.running[sensor.Name]
+        if ok && s.cfg == sensor {
+            continue
+        }
+
+        if !ok {
+            var err error
+            s, err = r.start(sensor)
+            if err != nil {
+                log.Printf("Unable to start %s: %v", sensor.Name, err)
+                continue
+            }
+            r.running[sensor.Name] = s
+        } else {
+            // its job reads s.cfg, so it is stopped before s changes
+            r.scheduler.Remove(sensor.Name)
+            if s.cfg.Location != sensor.Location {
+                forget(s.cfg)
+            }
+        }
+
+        s.cfg = sensor
+        r.scheduler.Add(schedule.Job{
+            Name:     sensor.Name,
+            Interval: sensor.Interval,
+            Lead:     s.lead,
+            Prepare:  s.prepare,
+            Read: func() {
+                r.check(s, s.read(sensor))
+            },
+        })
+    }
+}
+
+// samePlace reports whether a and b are the same sensor on the same bus and
+// address, so it doesn't need setting up again.
+func samePlace(a config.Instance, b config.Instance) bool {
+    return a.Type == b.Type && a.Bus == b.Bus && a.Address == b.Address
+}
+
+// start sets up sensor, without scheduling it.
+func (r *sensorRunner) start(sensor config.Instance) (*runningSensor, error) {
+    bus, err := r.busFor(sensor.Bus)
+    if err != nil {
+        return nil, err
+    }
+
+    s := &runningSensor{cfg: sensor, bus: bus}
+
+    switch sensor.Type {
+    case "htu31d":
+        // conversions are timed, so its reads go first
+        d := htu31.NewAt(bus.device(sensor.Name, i2cbus.High), sensor.Address)
+        log.Printf("%s serial %d\n", sensor.Name, d.Init())
+        err = d.Err()
+
+        s.lead = htu31.ConversionPause
+        s.prepare = d.Conversion
+        s.reset = d.Reset
+        s.read = func(sensor config.Instance) error {
+            temperature, humidity := d.ReadTempHumid()
+            err := d.Err()
+            if err == nil {
+                send(r.tempHumidity, reading[htu31.TempHumidity]{sensor, htu31.TempHumidity{Temp: temperature, Humidity: humidity}})
+            }
+            return err
+        }
+
+    case "pmsa003i":
+        // long reads that can wait
+        d := pmsa003i.NewAt(bus.device(sensor.Name, i2cbus.Low), sensor.Address)
+
+        // it has no soft reset over I2C, so only the bus is recovered
+        s.read = func(sensor config.Instance) error {
+            value := d.Read()
+            err := d.Err()
+            if err == nil {
+                send(r.pm, reading[pmsa003i.PMSensorValue]{sensor, value})
+            }
+            return err
+        }
+
+    case "dps310":
+        d := dps310.NewAt(bus.device(sensor.Name, i2cbus.Normal), sensor.Address)
+        err = d.Err()
+
+        s.reset = d.Reset
+        s.read = func(sensor config.Instance) error {
+            temperature := d.GetTemperature()
+            pressure := d.GetPressure()
+            err := d.Err()
+            if err == nil {
+                send(r.pressure, reading[dps310.TempPressure]{sensor, dps310.TempPressure{Temp: temperature, Pressure: pressure}})
+            }
+            return err
+        }
+
+    default:
+        return nil, fmt.Errorf("unknown sensor type %q", sensor.Type)
+    }
+
+    r.started(s, err)
+    return s, nil
+}
+
+// stop stops the named sensor and drops its metrics, so a sensor that has
+// gone doesn't keep reporting its last reading.
+func (r *sensorRunner) stop(name string) {
+    r.scheduler.Remove(name)
+    if s, ok := r.running[name]; ok {
+        forget(s.cfg)
+        delete(r.running, name)
+    }
+}
+
+// busFor returns the bus called name, opening it if it isn't open yet. A
+// mux channel shares the bus the mux is on, so its sensors wait for that bus
+// in priority order with the others on it.
+func (r *sensorRunner) busFor(name string) (*sensorBus, error) {
+    if name == "" || name == r.cfgBuses.I2C {
+        return r.main, nil
+    }
+    if bus, ok := r.buses[name]; ok {
+        return bus, nil
+    }
+
+    if muxName, n, ok := config.MuxChannel(name); ok {
+        mux, upstream, err := r.muxFor(muxName)
+        if err != nil {
+            return nil, err
+        }
+        channel, err := mux.Channel(n)
+        if err != nil {
+            return nil, err
+        }
+        bus := &sensorBus{bus: upstream, channel: channel}
+        r.buses[name] = bus
+        return bus, nil
+    }
+
+    opened, err := i2cbus.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    opened.OnTx = recordTx
+
+    bus := &sensorBus{bus: opened}
+    r.buses[name] = bus
+    return bus, nil
+}
+
+// muxFor returns the mux called name in buses.muxes and the bus it is on.
+func (r *sensorRunner) muxFor(name string) (*tca9548a.Mux, *i2cbus.Bus, error) {
+    for _, cfg := range r.cfgBuses.Muxes {
+        if cfg.Name != name {
+            continue
+        }
+
+        // muxes aren't chained, so this is never a channel
+        bus, err := r.busFor(cfg.Bus)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        if mux, ok := r.muxes[name]; ok {
+            return mux, bus.bus, nil
+        }
+
+        // selecting a channel on its own, outside a sensor's transaction,
+        // holds up the whole bus, so it goes first
+        mux := tca9548a.NewAt(bus.device("mux "+name, i2cbus.High), cfg.Address)
+        // disconnect any channel left connected, such as by a crash
+        err = mux.Select(-1)
+        if err != nil {
+            return nil, nil, err
+        }
+        r.muxes[name] = mux
+        return mux, bus.bus, nil
+    }
+
+    return nil, nil, fmt.Errorf("no mux called %q", name)
+}
+
+// close stops the sensors and closes the buses opened for them.
+func (r *sensorRunner) close() {
+    r.scheduler.Stop()
+    for name, bus := range r.buses {
+        if bus.channel == nil {
+            bus.bus.Close()
+        }
+        delete(r.buses, name)
+    }
+}
+
+// detect probes each bus for the sensors on it. A sensor found where it is
+// configured is kept there. One that isn't is moved to another address its
+// type was found at, if no other sensor has it, or else turned off. A
+// sensor that is already running is kept where it is if it doesn't answer,
+// so one that is failing is left to its tracker rather than turned off by a
+// reload. Each of the disabled sensors found at an address no other sensor
+// has is turned on there.
+func (r *sensorRunner) detect(instances []config.Instance, disabled []config.Instance) []config.Instance {
+    type place struct {
+        bus  *sensorBus
+        addr uint16
+    }
+
+    scans := make(map[*sensorBus][]detect.Result)
+    found := make(map[place]detect.Result)
+    // busFor returns the bus sensor is on, scanning it the first time
+    busFor := func(sensor config.Instance) *sensorBus {
+        bus, err := r.busFor(sensor.Bus)
+        if err != nil {
+            // left for start to report
+            return nil
+        }
+
+        if _, ok := scans[bus]; !ok {
+            scans[bus] = detect.Scan(bus.device("detect", i2cbus.Normal))
+            for _, result := range scans[bus] {
+                if result.Found() {
+                    found[place{bus, result.Addr}] = result
+                }
+            }
+        }
+        return bus
+    }
+
+    buses := make([]*sensorBus, len(instances))
+    for i, sensor := range instances {
+        buses[i] = busFor(sensor)
+    }
+
+    // whether a sensor of type kind was found at p
+    is := func(p place, kind string) bool {
+        result, ok := found[p]
+        return ok && strings.EqualFold(result.Sensor, kind)
+    }
+
+    claimed := make(map[place]bool)
+    for i, sensor := range instances {
+        if p := (place{buses[i], sensor.Address}); buses[i] != nil && is(p, sensor.Type) {
+            claimed[p] = true
+        }
+    }
+
+    var detected []config.Instance
+    for i, sensor := range instances {
+        bus := buses[i]
+        if bus == nil {
+            detected = append(detected, sensor)
+            continue
+        }
+
+        if p := (place{bus, sensor.Address}); is(p, sensor.Type) {
+            log.Printf("%s found at 0x%02x on %s, %s", sensor.Name, sensor.Address, bus, found[p].Detail)
+            detected = append(detected, sensor)
+            continue
+        }
+
+        if s, ok := r.running[sensor.Name]; ok && s.cfg.Type == sensor.Type {
+            log.Printf("%s not found, keeping it at 0x%02x on %s", sensor.Name, s.cfg.Address, s.bus)
+            sensor.Bus = s.cfg.Bus
+            sensor.Address = s.cfg.Address
+            detected = append(detected, sensor)
+            continue
+        }
+
+        moved := false
+        for _, result := range scans[bus] {
+            p := place{bus, result.Addr}
+            if !is(p, sensor.Type) || claimed[p] {
+                continue
+            }
+            log.Printf("%s found at 0x%02x rather than 0x%02x on %s, %s", sensor.Name, result.Addr, sensor.Address, bus, result.Detail)
+            claimed[p] = true
+            sensor.Address = result.Addr
+            moved = true
+            break
+        }
+        if !moved {
+            log.Printf("%s not found on %s, disabling it", sensor.Name, bus)
+            continue
+        }
+        detected = append(detected, sensor)
+    }
+
+    for _, sensor := range disabled {
+        bus := busFor(sensor)
+        if bus == nil {
+            continue
+        }
+
+        // the configured address first, then the others in scan order
+        addrs := []uint16{sensor.Address}
+        for _, result := range scans[bus] {
+            addrs = append(addrs, result.Addr)
+        }
+        for _, addr := range addrs {
+            p := place{bus, addr}
+            if !is(p, sensor.Type) || claimed[p] {
+                continue
+            }
+            log.Printf("%s found at 0x%02x on %s, %s, enabling it", sensor.Name, addr, bus, found[p].Detail)
+            claimed[p] = true
+            sensor.Address = addr
+            detected = append(detected, sensor)
+            break
+        }
+    }
+
+    return detected
+}
+
+// started gives a newly set up sensor a fresh tracker. A sensor that failed
+// to start is left to the tracker to recover, like any other failure.
+func (r *sensorRunner) started(s *runningSensor, err error) {
+    s.tracker = health.NewTracker()
+    metrics.SensorHealthy.WithLabelValues(s.cfg.Name).Set(1)
+    metrics.SensorConsecutiveFailures.WithLabelValues(s.cfg.Name).Set(0)
+
+    if err != nil {
+        log.Printf("%s failed to start: %v", s.cfg.Name, err)
+    }
+}
+
+// check records how a read went and, if it failed, recovers the sensor when
+// the tracker says to.
+func (r *sensorRunner) check(s *runningSensor, err error) {
+    sensor, tracker := s.cfg.Name, s.tracker
+
+    if err == nil {
+        if !tracker.Healthy() {
+            log.Printf("%s recovered after %d failed reads", sensor, tracker.Failures())
+        }
+        tracker.Success()
+        metrics.SensorHealthy.WithLabelValues(sensor).Set(1)
+        metrics.SensorConsecutiveFailures.WithLabelValues(sensor).Set(0)
+        return
+    }
+
+    action := tracker.Failure(time.Now())
+    log.Printf("%s read failed, %d in a row: %v", sensor, tracker.Failures(), err)
+
+    metrics.SensorConsecutiveFailures.WithLabelValues(sensor).Set(float64(tracker.Failures()))
+    if !tracker.Healthy() {
+        metrics.SensorHealthy.WithLabelValues(sensor).Set(0)
+    }
+
+    if action == health.RecoverBus {
+        // which for a mux channel is the bus the mux is on
+        log.Printf("Recovering the I2C bus %s for %s", s.bus.bus, sensor)
+        recovered(sensor, action, s.bus.bus.Recover())
+    }
+
+    if action != health.None && s.reset != nil {
+        log.Printf("Resetting %s", sensor)
+        recovered(sensor, health.Reset, s.reset())
+    }
+}
+
+func recovered(sensor string, action health.Action, err error) {
+    result := "ok"
+    if err != nil {
+        result = "failed"
+        log.Printf("%s %s recovery failed: %v", sensor, action, err)
+    }
+    metrics.SensorRecoveries.WithLabelValues(sensor, action.String(), result).Inc()
+}
+
+// forget drops the metrics of a sensor that has stopped or moved location.
+func forget(sensor config.Instance) {
+    metrics.SensorHealthy.DeleteLabelValues(sensor.Name)
+    metrics.SensorConsecutiveFailures.DeleteLabelValues(sensor.Name)
+
+    var gauges []*prometheus.GaugeVec
+    switch sensor.Type {
+    case "htu31d":
+        gauges = []*prometheus.GaugeVec{metrics.TemperatureMetric, metrics.HumidityMetric}
+    case "pmsa003i":
+        gauges = []*prometheus.GaugeVec{
+            metrics.PM10StdMetric, metrics.PM25StdMetric, metrics.PM100StdMetric,
+            metrics.PM10Concentration, metrics.PM25Concentration, metrics.PM100Concentration,
+        }
+    case "dps310":
+        gauges = []*prometheus.GaugeVec{metrics.PressureMetric}
+    }
+    for _, gauge := range gauges {
+        gauge.DeleteLabelValues(sensor.Name, sensor.Location)
+    }
+}
+
+// send hands value to the main loop without waiting, so a job never holds up
+// a reload that is waiting for it to finish. If the main loop has fallen
+// behind, the oldest waiting reading makes room, as only the latest matter.
+func send[T any](ch chan reading[T], value reading[T]) {
+    for {
+        select {
+        case ch <- value:
+            return
+        default:
+        }
+
+        select {
+        case old := <-ch:
+            log.Printf("Dropping %s reading, the main loop is behind", old.sensor.Name)
+        default:
+        }
+    }
+}
+
+func recordRun(run schedule.Run) {
+    metrics.SensorReadDelay.WithLabelValues(run.Job).Observe(run.Late.Seconds())
+    metrics.SensorReadDuration.WithLabelValues(run.Job).Observe(run.Took.Seconds())
+    if run.Missed > 0 {
+        metrics.SensorOverruns.WithLabelValues(run.Job).Add(float64(run.Missed))
+        log.Printf("%s overran, skipped %d reads", run.Job, run.Missed)
+    }
+}
+
+func recordTx(tx i2cbus.Tx) {
+    metrics.I2CWait.WithLabelValues(tx.Device).Observe(tx.Wait.Seconds())
+
+    switch {
+    case errors.Is(tx.Err, i2cbus.ErrTimeout):
+        metrics.I2CErrors.WithLabelValues(tx.Device, "timeout").Inc()
+    case tx.Err != nil:
+        metrics.I2CDuration.WithLabelValues(tx.Device).Observe(tx.Took.Seconds())
+        metrics.I2CErrors.WithLabelValues(tx.Device, "error").Inc()
+    default:
+        metrics.I2CDuration.WithLabelValues(tx.Device).Observe(tx.Took.Seconds())
+    }
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    "github.com/tony-tsang/airmon/internal/pkg"
+    "github.com/tony-tsang/airmon/internal/pkg/config"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/hko"
+    "github.com/tony-tsang/airmon/internal/pkg/metar"
+    "github.com/tony-tsang/airmon/internal/pkg/metrics"
+    "github.com/tony-tsang/airmon/internal/pkg/weather"
+)
+
+// newWeatherProvider returns the provider for cfg.Source, or nil for none.
+func newWeatherProvider(cfg config.Weather) (weather.Provider, error) {
+    switch cfg.Source {
+    case "hko":
+        client, err := newHKOClient(cfg.HKO)
+        if err != nil {
+            return nil, err
+        }
+
+        places := cfg.HKO.Places()
+        err = places.Validate()
+        if err != nil {
+            log.Printf("Warning: %v, the nearest station can't be found if it is missing", err)
+        }
+
+        return hko.NewProvider(client, places), nil
+
+    case "metar":
+        if cfg.METAR.Station == "" {
+            return nil, errors.New("no METAR station")
+        }
+        provider := metar.NewProvider(cfg.METAR.Station)
+        provider.URL = cfg.METAR.URL
+        return provider, nil
+
+    case "none", "":
+        return nil, nil
+    }
+
+    return nil, fmt.Errorf("unknown weather source %q", cfg.Source)
+}
+
+// newHKOClient returns a client for the configured API, language and timeout.
+func newHKOClient(cfg config.HKO) (*hko.Client, error) {
+    lang, err := hko.ParseLanguage(cfg.Language)
+    if err != nil {
+        return nil, err
+    }
+
+    client := hko.NewClient(nil)
+    client.Language = lang
+    client.BaseURL = cfg.URL
+    client.Timeout = cfg.Timeout
+    return client, nil
+}
+
+// applyReport copies the report into what the display shows.
+func applyReport(data *pkg.WeatherData, report weather.Report) {
+    data.OutdoorData = pkg.GeneralData{
+        Temperature: report.Temperature.Value,
+        Humidity:    report.Humidity.Value,
+        Pressure:    report.Pressure.Value,
+    }
+    data.GeneralSituation = report.GeneralSituation
+    data.Forecast = report.Forecast
+    data.Outlook = report.Outlook
+    data.Warnings = strings.Join(report.Messages, "\n")
+    data.Rainfall = report.Rainfall.Value
+    data.Icon = report.Icon
+    data.DailyForecast = report.DailyForecast
+    data.SpecialWeatherTips = report.SpecialWeatherTips
+
+    data.WarningDetails = nil
+    for _, warning := range report.Warnings {
+        if warning.Details != "" {
+            data.WarningDetails = append(data.WarningDetails, warning.Details)
+        } else {
+            data.WarningDetails = append(data.WarningDetails, warning.Name)
+        }
+    }
+}
+
+// recordRainfall adds the rainfall to the history, once for each hour the
+// reports were observed in: it is the total for the past hour, so charting
+// every report would count the same rain several times.
+func recordRainfall(readings *history.Store, report weather.Report) {
+    if report.Stale || !report.Rainfall.Valid() || report.Observed.IsZero() {
+        return
+    }
+
+    hour := report.Observed.Truncate(time.Hour)
+    if len(readings.Range(history.Rainfall, hour, hour.Add(time.Hour))) > 0 {
+        return
+    }
+    readings.Add(history.Rainfall, report.Observed, report.Rainfall.Value)
+}
+
+// recordWeatherMetrics publishes the observations, replacing the previous
+// ones since the station used can change when a reading is missing.
+func recordWeatherMetrics(report weather.Report) {
+    readings := []struct {
+        gauge   *prometheus.GaugeVec
+        reading weather.Reading
+    }{
+        {metrics.OutdoorTemperature, report.Temperature},
+        {metrics.OutdoorHumidity, report.Humidity},
+        {metrics.OutdoorPressure, report.Pressure},
+        {metrics.OutdoorRainfall, report.Rainfall},
+        {metrics.OutdoorUVIndex, report.UVIndex},
+    }
+
+    for _, r := range readings {
+        r.gauge.Reset()
+        if r.reading.Valid() {
+            r.gauge.WithLabelValues(report.Source, r.reading.Place).Set(r.reading.Value)
+        }
+    }
+
+    metrics.WeatherWarning.Reset()
+    for _, warning := range report.Warnings {
+        metrics.WeatherWarning.WithLabelValues(report.Source, warning.Code, warning.Subtype).Set(1)
+    }
+
+    if !report.Observed.IsZero() {
+        metrics.OutdoorUpdateTime.WithLabelValues(report.Source).Set(float64(report.Observed.Unix()))
+    }
+}
+
+func printPlaces(cfg config.HKO) {
+    client, err := newHKOClient(cfg)
+    if err != nil {
+        log.Fatalf("invalid weather.hko.language: %v", err)
+    }
+    lang := client.Language
+
+    list, err := client.Places(context.Background())
+    if err != nil {
+        log.Printf("No current weather report, listing known places: %v", err)
+        list = hko.KnownPlaces()
+    }
+
+    printPlaceList("Temperature stations:", list.TemperatureStations, lang)
+    printPlaceList("Humidity stations:", list.HumidityStations, lang)
+    printPlaceList("Rainfall districts:", list.RainfallDistricts, lang)
+}
+
+func printPlaceList(title string, places []hko.Place, lang hko.Language) {
+    fmt.Println(title)
+    for _, place := range places {
+        key := place.Key
+        if key == "" {
+            key = "-"
+        }
+        fmt.Printf("  %-30s %s\n", key, place.Name(lang))
+    }
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+    "errors"
+    "flag"
+    "fmt"
     "github.com/fogleman/gg"
     "github.com/makeworld-the-better-one/dither/v2"
-    "github.com/tony-tsang/airmon/assets"
     "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
     "github.com/tony-tsang/airmon/internal/pkg/hko"
+    "github.com/tony-tsang/airmon/internal/pkg/i2cbus"
+    "github.com/tony-tsang/airmon/internal/pkg/metrics"
+    "github.com/tony-tsang/airmon/internal/pkg/schedule"
     "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+    "github.com/tony-tsang/airmon/internal/pkg/widget"
+    "image"
     "image/color"
-    "image/png"
+    "image/draw"
     "log"
-    "periph.io/x/conn/v3/i2c/i2creg"
     "periph.io/x/conn/v3/physic"
     "periph.io/x/conn/v3/spi"
     "periph.io/x/conn/v3/spi/spireg"
@@ -26,6 +33,11 @@ type AllData struct {
 
 func main() {
 
+    var listenAddress string
+
+    flag.StringVar(&listenAddress, "listen", ":8081", "listen address for prometheus metrics")
+    flag.Parse()
+
     state, err := host.Init()
     if err != nil {
         log.Fatalf("failed to initialize periph: %v", err)
@@ -41,7 +53,7 @@ func main() {
         log.Fatalf("failed to open SPI: %v", err)
     }
 
-    i2cBus, err := i2creg.Open("")
+    i2cBus, err := i2cbus.Open("")
     if err != nil {
         log.Fatalf("failed to open I2C: %v", err)
     }
@@ -59,114 +71,115 @@ func main() {
     }
 
     d := new(uc8159.Display)
-    d.Init(spiChannel)
+    err = d.Init(spiChannel)
+    if err != nil {
+        log.Fatalf("failed to initialize display: %v", err)
+    }
 
     hkodatachannel := make(chan hko.HKOData)
-    go hko.DoLoop(hkodatachannel, 5*time.Minute)
+    go hko.DoLoop(hkodatachannel, 5*time.Minute, hko.DefaultPlaces)
 
     pressurechannel := make(chan dps310.TempPressure)
-    go dps310.DoLoop(i2cBus, pressurechannel, 10*time.Second)
 
-    context := gg.NewContext(640, 400)
+    pressureSensor := dps310.New(i2cBus)
+    if err := pressureSensor.Err(); err != nil {
+        log.Fatalf("failed to initialize DPS310: %v", err)
+    }
 
-    // fileData, err := os.ReadFile("NotoSansTC-Light.otf")
-    // if err != nil {
-    // 	log.Fatalf("Unable to load font file: %v", err)
-    // }
+    scheduler := schedule.New()
+    defer scheduler.Stop()
+
+    scheduler.Add(schedule.Job{
+        Name:     "dps310",
+        Interval: 10 * time.Second,
+        Read: func() {
+            temperature := pressureSensor.GetTemperature()
+            pressure := pressureSensor.GetPressure()
+            if err := pressureSensor.Err(); err != nil {
+                log.Printf("Error reading DPS310: %v", err)
+                return
+            }
+            pressurechannel <- dps310.TempPressure{Temp: temperature, Pressure: pressure}
+        },
+    })
 
-    //f, err := opentype.Parse(assets.FontData)
-    //if err != nil {
-    //    log.Fatalf("Unable to parse font: %v", err)
-    //}
+    go metrics.StartServer(listenAddress)
 
-    drawMutex := sync.Mutex{}
+    context := gg.NewContext(int(d.Width()), int(d.Height()))
 
-    context.SetColor(color.White)
-    context.Clear()
+    fonts, err := widget.DefaultFonts()
+    if err != nil {
+        log.Fatalf("Unable to parse font: %v", err)
+    }
+
+    readings := history.NewStore(72 * time.Hour)
+
+    drawMutex := sync.Mutex{}
 
     allData := AllData{}
 
     go func() {
 
+        width, height := int(d.Width()), int(d.Height())
+
         for {
             drawMutex.Lock()
 
-            pngFile, err := assets.IconFS.Open("icons/01n.png")
+            context.SetColor(color.White)
+            context.Clear()
+
+            canvas := context.Image().(draw.Image)
+            iconRect := image.Rect(0, 0, 128, 128)
+
+            widget.DrawIcon(canvas, iconRect, widget.HKOIcon(allData.HKOData.Icon, widget.IsNight(time.Now())))
+
+            text := fmt.Sprintf("%0.2f hPa %0.2f ℃", allData.TempPressure.Pressure, allData.TempPressure.Temp)
+            _, err := fonts.DrawText(canvas, text, widget.TextBox{
+                Rect:    image.Rect(iconRect.Max.X+10, 10, width-10, 60),
+                Size:    36,
+                MinSize: 20,
+                Color:   widget.Blue,
+            })
             if err != nil {
-                log.Fatal("Error opening 01n.png")
+                log.Printf("Error drawing text: %v", err)
             }
-            svgImage, err := png.Decode(pngFile)
+
+            _, err = fonts.DrawText(canvas, allData.HKOData.GeneralSituation, widget.TextBox{
+                Rect:        image.Rect(10, iconRect.Max.Y+10, width-10, height-170),
+                Size:        24,
+                MinSize:     16,
+                Color:       widget.Black,
+                LineSpacing: 1.2,
+            })
             if err != nil {
-                log.Fatal("Error decoding png")
+                log.Printf("Error drawing text: %v", err)
             }
 
-            /*
-               face, err := opentype.NewFace(f, &opentype.FaceOptions{
-                   Size:    24,
-                   DPI:     72,
-                   Hinting: font.HintingNone,
-               })
-               if err != nil {
-                   log.Fatalf("NewFace: %v", err)
-               }*/
-
-            /*
-               context.SetFontFace(face)
-               context.SetColor(color.Black)
-               context.DrawString(allData.HKOData.GeneralSituation, 0, 60)
-
-               face, err = opentype.NewFace(f, &opentype.FaceOptions{
-                   Size:    36,
-                   DPI:     72,
-                   Hinting: font.HintingNone,
-               })
-               if err != nil {
-                   log.Fatalf("NewFace: %v", err)
-               }
-               text := fmt.Sprintf("%0.2f hPa %0.2f ℃", allData.TempPressure.Pressure, allData.TempPressure.Temp)
-               context.SetFontFace(face)
-               w, h := context.MeasureString(text)
-               log.Printf("w=%v, h=%v", w, h)
-               context.SetColor(color.RGBA{0, 0, 255, 0xFF})
-               context.DrawRectangle(0, 0, w, h)
-               context.SetColor(color.Black)
-               context.DrawStringWrapped(text, 0, 32, 0, 0, 640, 2, gg.AlignLeft)
-
-               image := context.Image()
-            */
-            palette := color.Palette{
-                color.RGBA{0, 0, 0, 0xFF},
-                color.RGBA{255, 255, 255, 0xFF},
-                color.RGBA{0, 255, 0, 0xFF},
-                color.RGBA{0, 0, 255, 0xFF},
-                color.RGBA{255, 0, 0, 0xFF},
-                color.RGBA{255, 255, 0, 0xFF},
-                color.RGBA{255, 140, 0, 0xFF},
-                color.RGBA{255, 255, 255, 0xFF},
+            now := time.Now()
+            err = widget.LineChart(canvas, image.Rect(10, height-160, width-10, height-10),
+                readings.Range(history.Pressure, now.Add(-72*time.Hour), now),
+                widget.ChartOptions{
+                    From:       now.Add(-72 * time.Hour),
+                    To:         now,
+                    Line:       widget.Blue,
+                    Band:       widget.Yellow,
+                    Fonts:      fonts,
+                    Format:     "%.0f hPa",
+                    TimeFormat: "Jan 2",
+                })
+            if err != nil {
+                log.Printf("Error drawing chart: %v", err)
             }
 
-            ditherer := dither.NewDitherer(palette)
-            ditherer.Matrix = dither.FloydSteinberg
-            dithered := ditherer.Dither(svgImage)
-
-            for y := 0; y < 400; y++ {
-                for x := 0; x < 640; x++ {
-                    pixel := dithered.At(x, y)
-                    var index int
-                    _, _, _, alpha := pixel.RGBA()
-                    if alpha == 0 {
-                        index = 1
-                    } else {
-                        index = matchPalette(palette, pixel)
-                    }
-                    d.SetPixel(x, y, uc8159.Color(index))
-                }
-            }
+            widget.Sparkline(canvas, image.Rect(iconRect.Max.X+10, 70, width-10, iconRect.Max.Y),
+                readings.Range(history.Temperature, now.Add(-24*time.Hour), now), widget.Red)
+
+            opts := uc8159.DefaultImageOptions
+            opts.Matrix = dither.FloydSteinberg
+            d.DrawImage(canvas, &opts)
 
-            d.UpdateScreen()
+            updateScreen(d)
             drawMutex.Unlock()
-            context.SetColor(color.White)
-            context.Clear()
             time.Sleep(2 * time.Minute)
         }
     }()
@@ -184,7 +197,10 @@ func main() {
             log.Printf("tempPressure %v", tempData)
             drawMutex.Lock()
             allData.TempPressure = tempData
+            d.SetAmbientTemperature(tempData.Temp)
             drawMutex.Unlock()
+            readings.Add(history.Temperature, time.Now(), tempData.Temp)
+            readings.Add(history.Pressure, time.Now(), tempData.Pressure)
         default:
             time.Sleep(5 * time.Second)
         }
@@ -192,6 +208,31 @@ func main() {
     }
 }
 
-func matchPalette(palette color.Palette, pixel color.Color) int {
-    return palette.Index(pixel)
+func updateScreen(d *uc8159.Display) {
+
+    err := d.UpdateScreen()
+
+    switch {
+    case errors.Is(err, uc8159.ErrTemperatureRange):
+        ambient, _ := d.AmbientTemperature()
+        log.Printf("Deferring refresh, ambient temperature %.1f is out of range", ambient)
+        metrics.DisplayDeferredRefreshes.Inc()
+    case errors.Is(err, uc8159.ErrBusyTimeout):
+        log.Printf("Display refresh timed out, retrying next time")
+        metrics.DisplayBusyTimeouts.Inc()
+    case err != nil:
+        log.Printf("Display refresh failed, retrying next time: %v", err)
+    }
+
+    if err != nil {
+        return
+    }
+
+    if temperature, err := d.PanelTemperature(); err == nil {
+        metrics.DisplayPanelTemperature.WithLabelValues(d.TemperatureSource()).Set(temperature)
+    }
+
+    if status, err := d.Status(); err == nil && status&uc8159.StatusI2CError != 0 {
+        log.Printf("Display controller reports a temperature sensor error, status 0x%02x", byte(status))
+    }
 }
@@ -1,14 +1,13 @@
 package main
 
 import (
+    "flag"
     "image"
-    "image/color"
     _ "image/jpeg"
+    _ "image/png"
     "log"
     "os"
 
-    "github.com/makeworld-the-better-one/dither/v2"
-    "periph.io/x/conn/v3/i2c/i2creg"
     "periph.io/x/conn/v3/physic"
     "periph.io/x/conn/v3/spi"
     "periph.io/x/conn/v3/spi/spireg"
@@ -18,6 +17,34 @@ import (
 )
 
 func main() {
+    var ditherName string
+    var paletteName string
+    var panelName string
+    var rotation int
+
+    flag.StringVar(&ditherName, "dither", "jarvis", "dithering: floyd-steinberg, jarvis, atkinson, stucki, burkes, sierra, sierra-lite, bayer or none")
+    flag.StringVar(&paletteName, "palette", "calibrated", "palette to dither against: calibrated or saturated")
+    flag.StringVar(&panelName, "panel", "5.7", "panel size: 4, 5.7 or 7.3")
+    flag.IntVar(&rotation, "rotate", 0, "clockwise rotation in degrees: 0, 90, 180 or 270")
+    flag.Parse()
+
+    config, ok := uc8159.PanelByName(panelName)
+    if !ok {
+        log.Fatalf("unknown panel %q", panelName)
+    }
+    config.Rotation = uc8159.Rotation(rotation)
+
+    opts := uc8159.DefaultImageOptions
+    if !uc8159.ParseDither(ditherName, &opts) {
+        log.Fatalf("unknown dither %q", ditherName)
+    }
+
+    palette, ok := uc8159.ParsePalette(paletteName)
+    if !ok {
+        log.Fatalf("unknown palette %q", paletteName)
+    }
+    opts.Palette = palette
+
     state, err := host.Init()
     if err != nil {
         log.Fatalf("failed to initialize periph: %v", err)
@@ -38,13 +65,7 @@ func main() {
         log.Fatalf("failed to open SPI: %v", err)
     }
 
-    i2cBus, err := i2creg.Open("")
-    if err != nil {
-        log.Fatalf("failed to open I2C: %v", err)
-    }
-
     defer spiBus.Close()
-    defer i2cBus.Close()
 
     spiChannel, err := spiBus.Connect(physic.MegaHertz, spi.Mode3|spi.NoCS, 8)
 
@@ -56,13 +77,16 @@ func main() {
     }
 
     d := new(uc8159.Display)
-    d.Init(spiChannel)
+    err = d.InitConfig(spiChannel, config)
+    if err != nil {
+        log.Fatalf("Error: %v", err)
+    }
 
-    if len(os.Args) < 2 {
+    if flag.NArg() < 1 {
         log.Fatal("no file supplied")
     }
 
-    filename := os.Args[1]
+    filename := flag.Arg(0)
 
     imageFile, err := os.Open(filename)
 
@@ -78,46 +102,12 @@ func main() {
         log.Fatalf("Error: %v", err)
     }
 
-    palette := color.Palette{
-        color.RGBA{0, 0, 0, 0xFF},
-        color.RGBA{255, 255, 255, 0xFF},
-        color.RGBA{0, 255, 0, 0xFF},
-        color.RGBA{0, 0, 255, 0xFF},
-        color.RGBA{255, 0, 0, 0xFF},
-        color.RGBA{255, 255, 0, 0xFF},
-        color.RGBA{255, 140, 0, 0xFF},
-        color.RGBA{255, 255, 255, 0xFF},
-    }
-
-    ditherer := dither.NewDitherer(palette)
-
-    ditherer.Matrix = dither.JarvisJudiceNinke
-    //ditherer.Matrix = dither.FloydSteinberg
-
-    img := ditherer.Dither(imageData)
-
-    // log.Printf("%v", img)
-
-    for y := 0; y < 400; y++ {
-        for x := 0; x < 640; x++ {
-            pixel := img.At(x, y)
-            index := matchPalette(palette, pixel)
-            d.SetPixel(x, y, uc8159.Color(index))
-        }
-    }
+    d.DrawImage(imageData, &opts)
 
     //d.Fill(uc8159.WHITE)
 
-    d.UpdateScreen()
-}
-
-func matchPalette(palette color.Palette, pixel color.Color) int {
-    //for i, paletteColor := range palette {
-    //    if paletteColor == pixel {
-    //        return i
-    //    }
-    //}
-    //
-    //return 0
-    return palette.Index(pixel)
+    err = d.UpdateScreen()
+    if err != nil {
+        log.Fatalf("Error: %v", err)
+    }
 }
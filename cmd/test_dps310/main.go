@@ -3,9 +3,9 @@ package main
 import (
     "fmt"
     "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "github.com/tony-tsang/airmon/internal/pkg/i2cbus"
     "log"
     "periph.io/x/conn/v3/driver/driverreg"
-    "periph.io/x/conn/v3/i2c/i2creg"
     "periph.io/x/host/v3"
     "time"
 )
@@ -22,7 +22,7 @@ func main() {
         log.Fatalf("failed to initialize periph: %v", err)
     }
 
-    i2cBus, err := i2creg.Open("")
+    i2cBus, err := i2cbus.Open("")
     if err != nil {
         log.Fatalf("failed to open I2C: %v", err)
     }
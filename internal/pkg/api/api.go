@@ -0,0 +1,155 @@
+// Package api serves the latest data as JSON, alongside the metrics.
+package api
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+
+    "github.com/tony-tsang/airmon/internal/pkg/weather"
+)
+
+type Server struct {
+    // Reload, if set, reloads the config for POST /api/reload. The request
+    // needs ReloadToken as a bearer token if it is set, and otherwise has to
+    // come from this machine.
+    Reload      func() (ReloadResult, error)
+    ReloadToken string
+
+    mu      sync.RWMutex
+    weather weather.Report
+}
+
+// ReloadResult lists the settings a reload changed and the subsystems it
+// restarted for them. Those in Restart only take effect once airmon is
+// restarted.
+type ReloadResult struct {
+    Changed []string `json:"changed"`
+    Applied []string `json:"applied"`
+    Restart []string `json:"restart,omitempty"`
+}
+
+func NewServer() *Server {
+    return &Server{}
+}
+
+// SetWeather replaces the outdoor weather served.
+func (s *Server) SetWeather(report weather.Report) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.weather = report
+}
+
+type warnings struct {
+    Messages           []string          `json:"messages"`
+    Warnings           []weather.Warning `json:"warnings"`
+    SpecialWeatherTips []string          `json:"specialWeatherTips"`
+}
+
+// Register adds the API handlers to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+
+    mux.HandleFunc("/api/weather", s.handle(func() any {
+        return s.weather
+    }))
+
+    mux.HandleFunc("/api/weather/forecast", s.handle(func() any {
+        return s.weather.DailyForecast
+    }))
+
+    mux.HandleFunc("/api/weather/warnings", s.handle(func() any {
+        return warnings{
+            Messages:           s.weather.Messages,
+            Warnings:           s.weather.Warnings,
+            SpecialWeatherTips: s.weather.SpecialWeatherTips,
+        }
+    }))
+
+    mux.HandleFunc("/api/reload", s.handleReload)
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if s.Reload == nil {
+        http.NotFound(w, r)
+        return
+    }
+    if !s.mayReload(r) {
+        http.Error(w, "forbidden", http.StatusForbidden)
+        return
+    }
+
+    result, err := s.Reload()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if result.Changed == nil {
+        result.Changed = []string{}
+    }
+    if result.Applied == nil {
+        result.Applied = []string{}
+    }
+
+    body, err := json.Marshal(result)
+    if err != nil {
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(body)
+}
+
+// mayReload checks the request's bearer token, or that it came from a
+// loopback address if there is no token.
+func (s *Server) mayReload(r *http.Request) bool {
+    if s.ReloadToken != "" {
+        auth := r.Header.Get("Authorization")
+        if !strings.HasPrefix(auth, "Bearer ") {
+            return false
+        }
+        token := strings.TrimPrefix(auth, "Bearer ")
+        return subtle.ConstantTimeCompare([]byte(token), []byte(s.ReloadToken)) == 1
+    }
+
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return false
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// handle serves the value returned by get, which is called under the read
+// lock.
+func (s *Server) handle(get func() any) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+
+        s.mu.RLock()
+        body, err := json.Marshal(get())
+        s.mu.RUnlock()
+
+        if err != nil {
+            log.Printf("Error encoding %s: %v", r.URL.Path, err)
+            http.Error(w, "internal error", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(body)
+    }
+}
@@ -0,0 +1,374 @@
+// Package config is the airmon daemon's configuration file. Settings come
+// from the defaults, then the YAML file, then AIRMON_* environment variables,
+// so a container can change one setting without its own copy of the file.
+package config
+
+import (
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "github.com/tony-tsang/airmon/internal/pkg/hko"
+    "github.com/tony-tsang/airmon/internal/pkg/htu31"
+    "github.com/tony-tsang/airmon/internal/pkg/metar"
+    "github.com/tony-tsang/airmon/internal/pkg/pmsa003i"
+    "github.com/tony-tsang/airmon/internal/pkg/screen"
+    "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+)
+
+// ENV_PREFIX starts the name of every environment variable override. The
+// rest is the key in upper case with dots as underscores, so
+// sensors.htu31d.interval is AIRMON_SENSORS_HTU31D_INTERVAL.
+const ENV_PREFIX = "AIRMON_"
+
+type Config struct {
+    Buses   Buses   `yaml:"buses"`
+    Sensors Sensors `yaml:"sensors"`
+    Metrics Listen  `yaml:"metrics"`
+    API     API     `yaml:"api"`
+    Weather Weather `yaml:"weather"`
+    Display Display `yaml:"display"`
+    Storage Storage `yaml:"storage"`
+
+    // where each key was set, for errors
+    lines map[string]int
+    envs  map[string]string
+}
+
+// Buses are periph bus names, such as "/dev/i2c-1" or "SPI0.0". An empty
+// name opens the first bus found.
+type Buses struct {
+    I2C   string `yaml:"i2c"`
+    SPI   string `yaml:"spi"`
+    Muxes []Mux  `yaml:"muxes"`
+}
+
+// Mux is a TCA9548A I2C multiplexer on Bus, empty for buses.i2c. A sensor
+// behind it gives its bus as the mux's name and the channel, such as
+// "mux:3".
+type Mux struct {
+    Name    string `yaml:"name"`
+    Bus     string `yaml:"bus"`
+    Address uint16 `yaml:"address"`
+}
+
+// MuxChannel splits a sensor's bus into the name of the mux and the channel,
+// if it is on a mux.
+func MuxChannel(bus string) (string, int, bool) {
+    name, channel, ok := strings.Cut(bus, ":")
+    if !ok {
+        return "", 0, false
+    }
+    n, err := strconv.Atoi(channel)
+    if err != nil {
+        return "", 0, false
+    }
+    return name, n, true
+}
+
+// Sensor is one of the built-in sensors. Bus is the periph name of the I2C
+// bus it is on, empty for buses.i2c, or a mux channel, and Location labels its metrics, such
+// as the room it is in.
+type Sensor struct {
+    Enabled  bool          `yaml:"enabled"`
+    Interval time.Duration `yaml:"interval"`
+    Address  uint16        `yaml:"address"`
+    Bus      string        `yaml:"bus"`
+    Location string        `yaml:"location"`
+}
+
+// Instance is one sensor to run. Extra sensors, such as a second DPS310 or
+// sensors in other rooms, are listed as instances in sensors.extra. A zero
+// Interval there is the interval of the built-in sensor of the same type,
+// and a zero Address is the driver's default.
+type Instance struct {
+    Type     string        `yaml:"type"`
+    Name     string        `yaml:"name"`
+    Location string        `yaml:"location"`
+    Bus      string        `yaml:"bus"`
+    Address  uint16        `yaml:"address"`
+    Interval time.Duration `yaml:"interval"`
+
+    // Primary is set for the built-in sensors, which feed the display and
+    // the history as well as the metrics.
+    Primary bool `yaml:"-"`
+}
+
+// sensorTypes are the Type of each built-in sensor, with its name and the
+// addresses it can be at, default first.
+var sensorTypes = []struct {
+    Type      string
+    Name      string
+    Addresses []uint16
+}{
+    {"htu31d", "HTU31D", []uint16{htu31.DefaultAddr, 0x41}},
+    {"pmsa003i", "PMSA003I", []uint16{pmsa003i.PmSensorAddr}},
+    {"dps310", "DPS310", []uint16{dps310.DefaultAddr, 0x76}},
+}
+
+// Sensors are the sensor boards. With Detect, the bus is probed for them at
+// start and reload: an enabled sensor that isn't found is turned off and one
+// found at its other address is moved there. With AutoEnable as well, a
+// disabled sensor whose chip answers with its product ID or serial is
+// turned on where it was found.
+type Sensors struct {
+    Detect     bool   `yaml:"detect"`
+    AutoEnable bool   `yaml:"auto_enable"`
+    HTU31D     Sensor `yaml:"htu31d"`
+    PMSA003I   Sensor `yaml:"pmsa003i"`
+    DPS310     Sensor `yaml:"dps310"`
+
+    Extra []Instance `yaml:"extra"`
+}
+
+// Listen is an HTTP listen address.
+type Listen struct {
+    Listen string `yaml:"listen"`
+}
+
+// API is where the JSON API is served, alongside the metrics if Listen is
+// empty. POST /api/reload needs ReloadToken as a bearer token if it is set,
+// and otherwise is only accepted from this machine.
+type API struct {
+    Listen      string `yaml:"listen"`
+    ReloadToken string `yaml:"reload_token"`
+}
+
+type Weather struct {
+    // Source is hko, metar or none.
+    Source   string        `yaml:"source"`
+    Interval time.Duration `yaml:"interval"`
+    HKO      HKO           `yaml:"hko"`
+    METAR    METAR         `yaml:"metar"`
+}
+
+// HKO options. Stations and the district are keys or names in any language,
+// as listed by airmon -hko-places.
+type HKO struct {
+    URL                string        `yaml:"url"`
+    Language           string        `yaml:"language"`
+    Timeout            time.Duration `yaml:"timeout"`
+    TemperatureStation string        `yaml:"temperature_station"`
+    HumidityStation    string        `yaml:"humidity_station"`
+    RainfallDistrict   string        `yaml:"rainfall_district"`
+}
+
+type METAR struct {
+    URL     string `yaml:"url"`
+    Station string `yaml:"station"`
+}
+
+type Display struct {
+    Enabled bool `yaml:"enabled"`
+    // Panel is the e-paper panel size: "4", "5.7" or "7.3".
+    Panel    string `yaml:"panel"`
+    Rotation int    `yaml:"rotation"`
+    // PageInterval switches pages on a timer; 0 only switches with the
+    // buttons.
+    PageInterval time.Duration `yaml:"page_interval"`
+    Pages        []string      `yaml:"pages"`
+    Pins         Pins          `yaml:"pins"`
+    Buttons      []string      `yaml:"buttons"`
+    // ReadPanel reads the controller's temperature and status after each
+    // refresh, for boards with MISO wired to the panel.
+    ReadPanel    bool          `yaml:"read_panel"`
+}
+
+// Pins are the panel's GPIO pins. Empty pins keep the panel's default.
+type Pins struct {
+    Reset string `yaml:"reset"`
+    Busy  string `yaml:"busy"`
+    DC    string `yaml:"dc"`
+    CS    string `yaml:"cs"`
+}
+
+// Storage is where the chart history is kept. With no Path the history is
+// only kept in memory.
+type Storage struct {
+    Path         string        `yaml:"path"`
+    Retention    time.Duration `yaml:"retention"`
+    SaveInterval time.Duration `yaml:"save_interval"`
+}
+
+// Default returns the settings airmon uses without a config file.
+func Default() Config {
+    return Config{
+        Sensors: Sensors{
+            Detect:     true,
+            AutoEnable: true,
+            HTU31D:     Sensor{Enabled: true, Interval: 10 * time.Second, Address: htu31.DefaultAddr},
+            PMSA003I:   Sensor{Enabled: true, Interval: 10 * time.Second, Address: pmsa003i.PmSensorAddr},
+            DPS310:     Sensor{Enabled: true, Interval: 10 * time.Second, Address: dps310.DefaultAddr},
+        },
+        Metrics: Listen{Listen: ":8080"},
+        Weather: Weather{
+            Source:   "hko",
+            Interval: 5 * time.Minute,
+            HKO: HKO{
+                URL:                hko.API_URL,
+                Language:           string(hko.TraditionalChinese),
+                Timeout:            15 * time.Second,
+                TemperatureStation: hko.DefaultPlaces.TemperatureStation,
+                HumidityStation:    hko.DefaultPlaces.HumidityStation,
+                RainfallDistrict:   hko.DefaultPlaces.RainfallDistrict,
+            },
+            METAR: METAR{URL: metar.DEFAULT_URL, Station: "VHHH"},
+        },
+        Display: Display{
+            Panel:   "5.7",
+            Pages:   []string{"overview", "air", "forecast", "sensors"},
+            Buttons: append([]string(nil), screen.DefaultButtonPins...),
+        },
+        Storage: Storage{Retention: 72 * time.Hour, SaveInterval: 5 * time.Minute},
+    }
+}
+
+// Load returns the defaults overridden by the file at path, if path isn't
+// empty, and then the environment. Problems are returned as Errors, naming
+// each offending key.
+func Load(path string) (Config, error) {
+    c := Default()
+
+    if path != "" {
+        body, err := os.ReadFile(path)
+        if err != nil {
+            return c, err
+        }
+        err = c.decode(body)
+        if err != nil {
+            return c, err
+        }
+    }
+
+    err := c.applyEnv(os.Environ())
+    if err != nil {
+        return c, err
+    }
+
+    return c, c.Validate()
+}
+
+// Parse is Load for a file already read, without the environment.
+func Parse(body []byte) (Config, error) {
+    c := Default()
+
+    err := c.decode(body)
+    if err != nil {
+        return c, err
+    }
+
+    return c, c.Validate()
+}
+
+// Places are the HKO places to report.
+func (h HKO) Places() hko.Places {
+    return hko.Places{
+        TemperatureStation: h.TemperatureStation,
+        HumidityStation:    h.HumidityStation,
+        RainfallDistrict:   h.RainfallDistrict,
+    }
+}
+
+// builtIn returns the built-in sensor of type kind.
+func (s *Sensors) builtIn(kind string) (*Sensor, bool) {
+    switch kind {
+    case "htu31d":
+        return &s.HTU31D, true
+    case "pmsa003i":
+        return &s.PMSA003I, true
+    case "dps310":
+        return &s.DPS310, true
+    }
+    return nil, false
+}
+
+// Instances returns every enabled sensor, the built-in ones first, with the
+// defaults of the extra ones filled in.
+func (s Sensors) Instances() []Instance {
+    instances := s.builtIns(true)
+
+    for _, extra := range s.Extra {
+        for _, t := range sensorTypes {
+            if t.Type != extra.Type {
+                continue
+            }
+            if extra.Address == 0 {
+                extra.Address = t.Addresses[0]
+            }
+            if extra.Interval == 0 {
+                sensor, _ := s.builtIn(t.Type)
+                extra.Interval = sensor.Interval
+            }
+        }
+        instances = append(instances, extra)
+    }
+
+    return instances
+}
+
+// Disabled returns the built-in sensors that are turned off, for detection
+// to turn on if they are found. An interval or address that wasn't checked,
+// since the sensor was off, is replaced by the default.
+func (s Sensors) Disabled() []Instance {
+    defaults := Default().Sensors
+    instances := s.builtIns(false)
+    for i, sensor := range instances {
+        builtIn, _ := defaults.builtIn(sensor.Type)
+        if sensor.Interval < time.Second {
+            instances[i].Interval = builtIn.Interval
+        }
+        for _, t := range sensorTypes {
+            if t.Type == sensor.Type && !hasAddress(t.Addresses, sensor.Address) {
+                instances[i].Address = builtIn.Address
+            }
+        }
+    }
+    return instances
+}
+
+// builtIns returns the built-in sensors that are, or aren't, enabled.
+func (s Sensors) builtIns(enabled bool) []Instance {
+    var instances []Instance
+    for _, t := range sensorTypes {
+        sensor, _ := s.builtIn(t.Type)
+        if sensor.Enabled != enabled {
+            continue
+        }
+        instances = append(instances, Instance{
+            Type:     t.Type,
+            Name:     t.Name,
+            Location: sensor.Location,
+            Bus:      sensor.Bus,
+            Address:  sensor.Address,
+            Interval: sensor.Interval,
+            Primary:  true,
+        })
+    }
+    return instances
+}
+
+// PanelConfig returns the panel preset with the rotation and pins applied.
+func (d Display) PanelConfig() (uc8159.Config, bool) {
+    panel, ok := uc8159.PanelByName(d.Panel)
+    if !ok {
+        return panel, false
+    }
+
+    panel.Rotation = uc8159.Rotation(d.Rotation)
+    if d.Pins.Reset != "" {
+        panel.ResetPin = d.Pins.Reset
+    }
+    if d.Pins.Busy != "" {
+        panel.BusyPin = d.Pins.Busy
+    }
+    if d.Pins.DC != "" {
+        panel.DCPin = d.Pins.DC
+    }
+    if d.Pins.CS != "" {
+        panel.CSPin = d.Pins.CS
+    }
+
+    return panel, true
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// errorKeys returns the key and line of each Error in err.
+func errorKeys(t *testing.T, err error) []Error {
+    t.Helper()
+
+    var errs Errors
+    if !errors.As(err, &errs) {
+        t.Fatalf("got %v, want Errors", err)
+    }
+
+    var keys []Error
+    for _, e := range errs {
+        keys = append(keys, Error{Key: e.Key, Line: e.Line, Env: e.Env})
+    }
+    return keys
+}
+
+func TestDefaultIsValid(t *testing.T) {
+    c := Default()
+    if err := c.Validate(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestParseUnknownKey(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want Error
+    }{
+        {"top level", "sensor:\n  detect: true\n", Error{Key: "sensor", Line: 1}},
+        {"nested", "sensors:\n  detect: true\n  htu31d:\n    intervall: 5s\n", Error{Key: "sensors.htu31d.intervall", Line: 4}},
+        {"list item", "sensors:\n  extra:\n    - type: dps310\n      name: DPS310b\n      adress: 0x76\n", Error{Key: "sensors.extra[0].adress", Line: 5}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := Parse([]byte(test.body))
+            keys := errorKeys(t, err)
+            if len(keys) != 1 || keys[0] != test.want {
+                t.Errorf("got %+v, want %+v", keys, test.want)
+            }
+        })
+    }
+}
+
+func TestParseWrongType(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want Error
+    }{
+        {"duration", "weather:\n  interval: often\n", Error{Key: "weather.interval", Line: 2}},
+        {"bool", "display:\n  enabled: maybe\n", Error{Key: "display.enabled", Line: 2}},
+        {"number", "sensors:\n  dps310:\n    address: [1]\n", Error{Key: "sensors.dps310.address", Line: 3}},
+        {"mapping", "storage: 5\n", Error{Key: "storage", Line: 1}},
+        {"list", "sensors:\n  extra:\n    type: dps310\n", Error{Key: "sensors.extra", Line: 3}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := Parse([]byte(test.body))
+            keys := errorKeys(t, err)
+            if len(keys) != 1 || keys[0] != test.want {
+                t.Errorf("got %+v, want %+v", keys, test.want)
+            }
+        })
+    }
+}
+
+func TestParseKeepsDefaults(t *testing.T) {
+    c, err := Parse([]byte("sensors:\n  htu31d:\n    interval: 30s\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if c.Sensors.HTU31D.Interval != 30*time.Second {
+        t.Errorf("interval %v, want 30s", c.Sensors.HTU31D.Interval)
+    }
+    if !c.Sensors.HTU31D.Enabled || c.Sensors.HTU31D.Address != Default().Sensors.HTU31D.Address {
+        t.Errorf("defaults not kept: %+v", c.Sensors.HTU31D)
+    }
+}
+
+func TestEnvOverrides(t *testing.T) {
+    tests := []struct {
+        name  string
+        env   string
+        check func(c Config) bool
+    }{
+        {"nested", "AIRMON_SENSORS_HTU31D_INTERVAL=30s", func(c Config) bool {
+            return c.Sensors.HTU31D.Interval == 30*time.Second
+        }},
+        {"deeply nested", "AIRMON_WEATHER_HKO_LANGUAGE=en", func(c Config) bool {
+            return c.Weather.HKO.Language == "en"
+        }},
+        {"bool", "AIRMON_DISPLAY_ENABLED=true", func(c Config) bool {
+            return c.Display.Enabled
+        }},
+        {"list of strings", "AIRMON_DISPLAY_PAGES=overview, trends,,sensors", func(c Config) bool {
+            return reflect.DeepEqual(c.Display.Pages, []string{"overview", "trends", "sensors"})
+        }},
+        {"list of mappings", "AIRMON_SENSORS_EXTRA=[{type: dps310, name: DPS310b, address: 0x76}]", func(c Config) bool {
+            return reflect.DeepEqual(c.Sensors.Extra, []Instance{{Type: "dps310", Name: "DPS310b", Address: 0x76}})
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            c := Default()
+            err := c.applyEnv([]string{"PATH=/bin", ENV_CONFIG + "=/etc/airmon.yaml", test.env})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !test.check(c) {
+                t.Errorf("%s not applied", test.env)
+            }
+        })
+    }
+}
+
+func TestEnvErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        env  string
+        want Error
+    }{
+        {"unknown", "AIRMON_SENSORS_HTU31_INTERVAL=5s", Error{Key: "sensors_htu31_interval", Env: "AIRMON_SENSORS_HTU31_INTERVAL"}},
+        {"wrong type", "AIRMON_STORAGE_RETENTION=forever", Error{Key: "storage.retention", Env: "AIRMON_STORAGE_RETENTION"}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            c := Default()
+            keys := errorKeys(t, c.applyEnv([]string{test.env}))
+            if len(keys) != 1 || keys[0] != test.want {
+                t.Errorf("got %+v, want %+v", keys, test.want)
+            }
+        })
+    }
+}
+
+func TestEnvErrorsNameTheVariable(t *testing.T) {
+    c := Default()
+    err := c.applyEnv([]string{"AIRMON_WEATHER_INTERVAL=30s"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    keys := errorKeys(t, c.Validate())
+    want := Error{Key: "weather.interval", Env: "AIRMON_WEATHER_INTERVAL"}
+    if len(keys) != 1 || keys[0] != want {
+        t.Errorf("got %+v, want %+v", keys, want)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    mux := Mux{Name: "mux", Address: 0x70}
+    dps := Instance{Type: "dps310", Name: "DPS310b", Address: 0x76}
+
+    tests := []struct {
+        key    string
+        change func(c *Config)
+    }{
+        {"buses.muxes[0].name", func(c *Config) { c.Buses.Muxes = []Mux{{Address: 0x70}} }},
+        {"buses.muxes[0].name", func(c *Config) { c.Buses.Muxes = []Mux{{Name: "a:b", Address: 0x70}} }},
+        {"buses.muxes[1].name", func(c *Config) { c.Buses.Muxes = []Mux{mux, {Name: "mux", Address: 0x71}} }},
+        {"buses.muxes[0].bus", func(c *Config) { c.Buses.Muxes = []Mux{{Name: "mux", Bus: "other:1", Address: 0x70}} }},
+        {"buses.muxes[0].address", func(c *Config) { c.Buses.Muxes = []Mux{{Name: "mux", Address: 0x60}} }},
+        {"buses.muxes[1].address", func(c *Config) { c.Buses.Muxes = []Mux{mux, {Name: "mux2", Address: 0x70}} }},
+
+        {"sensors.htu31d.interval", func(c *Config) { c.Sensors.HTU31D.Interval = 100 * time.Millisecond }},
+        {"sensors.htu31d.address", func(c *Config) { c.Sensors.HTU31D.Address = 0x42 }},
+        {"sensors.dps310.bus", func(c *Config) { c.Sensors.DPS310.Bus = "mux:x" }},
+        {"sensors.dps310.bus", func(c *Config) { c.Sensors.DPS310.Bus = "mux:1" }},
+        {"sensors.dps310.bus", func(c *Config) {
+            c.Buses.Muxes = []Mux{mux}
+            c.Sensors.DPS310.Bus = "mux:8"
+        }},
+
+        {"sensors.extra[0].name", func(c *Config) { c.Sensors.Extra = []Instance{{Type: "dps310", Address: 0x76}} }},
+        {"sensors.extra[0].name", func(c *Config) { c.Sensors.Extra = []Instance{{Type: "dps310", Name: "DPS310", Address: 0x76}} }},
+        {"sensors.extra[0].type", func(c *Config) { c.Sensors.Extra = []Instance{{Type: "bme280", Name: "BME"}} }},
+        {"sensors.extra[0].interval", func(c *Config) {
+            c.Sensors.Extra = []Instance{dps}
+            c.Sensors.Extra[0].Interval = time.Millisecond
+        }},
+        {"sensors.extra[0].address", func(c *Config) { c.Sensors.Extra = []Instance{{Type: "dps310", Name: "DPS310b", Address: 0x41}} }},
+        {"sensors.extra[0].address", func(c *Config) { c.Sensors.Extra = []Instance{{Type: "dps310", Name: "DPS310b"}} }},
+
+        {"metrics.listen", func(c *Config) { c.Metrics.Listen = "" }},
+        {"metrics.listen", func(c *Config) { c.Metrics.Listen = "8080" }},
+        {"api.listen", func(c *Config) { c.API.Listen = "localhost" }},
+
+        {"weather.source", func(c *Config) { c.Weather.Source = "bom" }},
+        {"weather.interval", func(c *Config) { c.Weather.Interval = time.Second }},
+        {"weather.hko.language", func(c *Config) { c.Weather.HKO.Language = "fr" }},
+        {"weather.hko.url", func(c *Config) { c.Weather.HKO.URL = "" }},
+        {"weather.hko.timeout", func(c *Config) { c.Weather.HKO.Timeout = 0 }},
+        {"weather.metar.station", func(c *Config) {
+            c.Weather.Source = "metar"
+            c.Weather.METAR.Station = "HK"
+        }},
+        {"weather.metar.url", func(c *Config) {
+            c.Weather.Source = "metar"
+            c.Weather.METAR.URL = ""
+        }},
+
+        {"display.panel", func(c *Config) { c.Display.Panel = "13.3" }},
+        {"display.rotation", func(c *Config) { c.Display.Rotation = 45 }},
+        {"display.page_interval", func(c *Config) { c.Display.PageInterval = -time.Second }},
+        {"display.pages", func(c *Config) {
+            c.Display.Enabled = true
+            c.Display.Pages = nil
+        }},
+        {"display.pages", func(c *Config) { c.Display.Pages = []string{"overview", "radar"} }},
+
+        {"storage.retention", func(c *Config) { c.Storage.Retention = 0 }},
+        {"storage.save_interval", func(c *Config) {
+            c.Storage.Path = "/var/lib/airmon/history"
+            c.Storage.SaveInterval = 0
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.key, func(t *testing.T) {
+            c := Default()
+            test.change(&c)
+
+            keys := errorKeys(t, c.Validate())
+            if len(keys) != 1 || keys[0].Key != test.key {
+                t.Errorf("got %+v, want %s", keys, test.key)
+            }
+        })
+    }
+}
+
+func TestValidateDisabledSensors(t *testing.T) {
+    c := Default()
+    c.Sensors.HTU31D = Sensor{Enabled: false, Address: 0x42}
+    if err := c.Validate(); err != nil {
+        t.Errorf("disabled sensor checked: %v", err)
+    }
+}
+
+func TestValidateLine(t *testing.T) {
+    _, err := Parse([]byte("weather:\n  source: hko\n  interval: 10s\n"))
+    keys := errorKeys(t, err)
+    want := Error{Key: "weather.interval", Line: 3}
+    if len(keys) != 1 || keys[0] != want {
+        t.Errorf("got %+v, want %+v", keys, want)
+    }
+}
+
+func TestDiff(t *testing.T) {
+    dps := Instance{Type: "dps310", Name: "DPS310b", Address: 0x76}
+
+    tests := []struct {
+        name   string
+        change func(c *Config)
+        want   []string
+    }{
+        {"nothing", func(c *Config) {}, nil},
+        {"sensor added", func(c *Config) { c.Sensors.Extra = []Instance{dps} }, []string{"sensors.extra"}},
+        {"sensor disabled", func(c *Config) {
+            c.Sensors.DPS310.Enabled = false
+        }, []string{"sensors.dps310.enabled"}},
+        {"sensor changed", func(c *Config) {
+            c.Sensors.HTU31D.Interval = time.Minute
+            c.Sensors.HTU31D.Location = "bedroom"
+        }, []string{"sensors.htu31d.interval", "sensors.htu31d.location"}},
+        {"several sections", func(c *Config) {
+            c.Weather.HKO.Language = "en"
+            c.Display.Pages = []string{"overview"}
+        }, []string{"display.pages", "weather.hko.language"}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            old, changed := Default(), Default()
+            test.change(&changed)
+
+            got := old.Diff(&changed)
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("got %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestDiffRemovedSensor(t *testing.T) {
+    dps := Instance{Type: "dps310", Name: "DPS310b", Address: 0x76}
+    other := Instance{Type: "htu31d", Name: "HTU31D bedroom", Address: 0x41}
+
+    old, changed := Default(), Default()
+    old.Sensors.Extra = []Instance{dps, other}
+    changed.Sensors.Extra = []Instance{other}
+
+    got := old.Diff(&changed)
+    if !reflect.DeepEqual(got, []string{"sensors.extra"}) {
+        t.Errorf("got %v, want [sensors.extra]", got)
+    }
+    if !Changed(got, "sensors") || Changed(got, "sensors.htu31d", "display") {
+        t.Errorf("Changed wrong for %v", got)
+    }
+}
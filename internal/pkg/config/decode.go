@@ -0,0 +1,263 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "regexp"
+    "sort"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ENV_CONFIG names the config file when -config isn't given.
+const ENV_CONFIG = ENV_PREFIX + "CONFIG"
+
+// Error is a problem with the setting at Key, such as
+// "sensors.htu31d.interval". Line is where it is in the file, or 0, and Env
+// is the environment variable it came from, if any.
+type Error struct {
+    Key  string
+    Line int
+    Env  string
+    Err  error
+}
+
+func (e *Error) Error() string {
+    switch {
+    case e.Env != "":
+        return fmt.Sprintf("%s (%s): %v", e.Key, e.Env, e.Err)
+    case e.Line > 0:
+        return fmt.Sprintf("%s (line %d): %v", e.Key, e.Line, e.Err)
+    }
+    return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+    return e.Err
+}
+
+// Errors is every problem found, in the order of the keys.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+    lines := make([]string, len(errs))
+    for i, err := range errs {
+        lines[i] = err.Error()
+    }
+    return strings.Join(lines, "\n")
+}
+
+func (errs Errors) err() error {
+    if len(errs) == 0 {
+        return nil
+    }
+    return errs
+}
+
+// yamlLine matches the position yaml adds to its own messages.
+var yamlLine = regexp.MustCompile(`^(yaml: )?line \d+: `)
+
+// decode overrides c with the settings in body. Keys are checked against
+// the struct tags so a misspelt key is an error rather than ignored.
+func (c *Config) decode(body []byte) error {
+    var doc yaml.Node
+
+    err := yaml.Unmarshal(body, &doc)
+    if err != nil {
+        return err
+    }
+
+    // an empty file
+    if len(doc.Content) == 0 {
+        return nil
+    }
+
+    c.lines = make(map[string]int)
+
+    var errs Errors
+    c.decodeNode(doc.Content[0], reflect.ValueOf(c).Elem(), "", &errs)
+    return errs.err()
+}
+
+func (c *Config) decodeNode(node *yaml.Node, v reflect.Value, key string, errs *Errors) {
+    if key != "" {
+        c.lines[key] = node.Line
+    }
+
+    if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+        c.decodeList(node, v, key, errs)
+        return
+    }
+
+    if v.Kind() != reflect.Struct {
+        err := node.Decode(v.Addr().Interface())
+        if err != nil {
+            *errs = append(*errs, &Error{Key: key, Line: node.Line, Err: cleanYAMLError(err)})
+        }
+        return
+    }
+
+    if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+        return
+    }
+    if node.Kind != yaml.MappingNode {
+        *errs = append(*errs, &Error{Key: rootKey(key), Line: node.Line, Err: errors.New("should be a mapping")})
+        return
+    }
+
+    fields := fieldsByName(v.Type())
+
+    for i := 0; i+1 < len(node.Content); i += 2 {
+        name := node.Content[i].Value
+        fieldKey := joinKey(key, name)
+
+        index, ok := fields[name]
+        if !ok {
+            *errs = append(*errs, &Error{Key: fieldKey, Line: node.Content[i].Line, Err: errors.New("unknown key")})
+            continue
+        }
+
+        c.decodeNode(node.Content[i+1], v.Field(index), fieldKey, errs)
+    }
+}
+
+// decodeList decodes a list of mappings item by item, so their keys are
+// checked too. Items are keyed like sensors.extra[0].
+func (c *Config) decodeList(node *yaml.Node, v reflect.Value, key string, errs *Errors) {
+    if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+        v.Set(reflect.Zero(v.Type()))
+        return
+    }
+    if node.Kind != yaml.SequenceNode {
+        *errs = append(*errs, &Error{Key: key, Line: node.Line, Err: errors.New("should be a list")})
+        return
+    }
+
+    list := reflect.MakeSlice(v.Type(), len(node.Content), len(node.Content))
+    for i, item := range node.Content {
+        c.decodeNode(item, list.Index(i), fmt.Sprintf("%s[%d]", key, i), errs)
+    }
+    v.Set(list)
+}
+
+// applyEnv overrides c with the AIRMON_* variables in environ, which is in
+// the form os.Environ returns. Lists are comma separated.
+func (c *Config) applyEnv(environ []string) error {
+    settings := make(map[string]reflect.Value)
+    keys := make(map[string]string)
+    walkLeaves(reflect.ValueOf(c).Elem(), "", func(key string, v reflect.Value) {
+        name := EnvName(key)
+        settings[name] = v
+        keys[name] = key
+    })
+
+    sort.Strings(environ)
+
+    var errs Errors
+    for _, variable := range environ {
+        name, value, _ := strings.Cut(variable, "=")
+        if !strings.HasPrefix(name, ENV_PREFIX) || name == ENV_CONFIG {
+            continue
+        }
+
+        v, ok := settings[name]
+        if !ok {
+            errs = append(errs, &Error{Key: strings.ToLower(strings.TrimPrefix(name, ENV_PREFIX)), Env: name, Err: errors.New("unknown setting")})
+            continue
+        }
+
+        err := setString(v, value)
+        if err != nil {
+            errs = append(errs, &Error{Key: keys[name], Env: name, Err: err})
+            continue
+        }
+
+        if c.envs == nil {
+            c.envs = make(map[string]string)
+        }
+        c.envs[keys[name]] = name
+    }
+
+    return errs.err()
+}
+
+// EnvName is the environment variable that overrides key.
+func EnvName(key string) string {
+    return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func setString(v reflect.Value, value string) error {
+    switch {
+    case v.Kind() == reflect.String:
+        v.SetString(value)
+        return nil
+
+    case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+        var items []string
+        for _, item := range strings.Split(value, ",") {
+            if item = strings.TrimSpace(item); item != "" {
+                items = append(items, item)
+            }
+        }
+        v.Set(reflect.ValueOf(items))
+        return nil
+    }
+
+    err := yaml.Unmarshal([]byte(value), v.Addr().Interface())
+    if err != nil {
+        return cleanYAMLError(err)
+    }
+    return nil
+}
+
+// walkLeaves calls leaf with the key of every setting below v.
+func walkLeaves(v reflect.Value, key string, leaf func(key string, v reflect.Value)) {
+    if v.Kind() != reflect.Struct {
+        leaf(key, v)
+        return
+    }
+
+    for name, index := range fieldsByName(v.Type()) {
+        walkLeaves(v.Field(index), joinKey(key, name), leaf)
+    }
+}
+
+// fieldsByName maps the yaml names of t's settings to their field index.
+func fieldsByName(t reflect.Type) map[string]int {
+    fields := make(map[string]int)
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+        if !field.IsExported() || name == "" || name == "-" {
+            continue
+        }
+        fields[name] = i
+    }
+    return fields
+}
+
+func joinKey(key string, name string) string {
+    if key == "" {
+        return name
+    }
+    return key + "." + name
+}
+
+func rootKey(key string) string {
+    if key == "" {
+        return "(top level)"
+    }
+    return key
+}
+
+// cleanYAMLError drops the position and prefix yaml adds, as Error gives
+// both the key and the line.
+func cleanYAMLError(err error) error {
+    var typeErr *yaml.TypeError
+    if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+        return errors.New(yamlLine.ReplaceAllString(typeErr.Errors[0], ""))
+    }
+    return errors.New(yamlLine.ReplaceAllString(err.Error(), ""))
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// Diff returns the keys of the settings that differ between c and other,
+// sorted.
+func (c *Config) Diff(other *Config) []string {
+    theirs := make(map[string]reflect.Value)
+    walkLeaves(reflect.ValueOf(other).Elem(), "", func(key string, v reflect.Value) {
+        theirs[key] = v
+    })
+
+    var changed []string
+    walkLeaves(reflect.ValueOf(c).Elem(), "", func(key string, v reflect.Value) {
+        if !reflect.DeepEqual(v.Interface(), theirs[key].Interface()) {
+            changed = append(changed, key)
+        }
+    })
+
+    sort.Strings(changed)
+    return changed
+}
+
+// Changed reports whether any of keys is, or is below, one of prefixes, such
+// as "weather" or "display.pins".
+func Changed(keys []string, prefixes ...string) bool {
+    for _, key := range keys {
+        for _, prefix := range prefixes {
+            if key == prefix || strings.HasPrefix(key, prefix+".") {
+                return true
+            }
+        }
+    }
+    return false
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/hko"
+    "github.com/tony-tsang/airmon/internal/pkg/screen"
+    "github.com/tony-tsang/airmon/internal/pkg/tca9548a"
+    "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+)
+
+// Validate checks the settings make sense, returning Errors naming every
+// offending key.
+func (c *Config) Validate() error {
+    var errs Errors
+
+    invalid := func(key string, format string, args ...any) {
+        errs = append(errs, &Error{Key: key, Line: c.lines[key], Env: c.envs[key], Err: fmt.Errorf(format, args...)})
+    }
+
+    // where each sensor is, to catch two at the same address
+    type place struct {
+        bus  string
+        addr uint16
+    }
+    places := make(map[place]string)
+    claim := func(key string, name string, bus string, addr uint16) {
+        if bus == c.Buses.I2C {
+            bus = ""
+        }
+        p := place{bus, addr}
+        if other, ok := places[p]; ok {
+            invalid(key+".address", "0x%02x is already used by %s", addr, other)
+            return
+        }
+        places[p] = name
+    }
+
+    muxes := make(map[string]bool)
+    for i, mux := range c.Buses.Muxes {
+        key := fmt.Sprintf("buses.muxes[%d]", i)
+
+        switch {
+        case mux.Name == "":
+            invalid(key+".name", "is empty")
+        case strings.Contains(mux.Name, ":"):
+            invalid(key+".name", "%q has a colon", mux.Name)
+        case muxes[mux.Name]:
+            invalid(key+".name", "%q is already used", mux.Name)
+        }
+        muxes[mux.Name] = true
+
+        if strings.Contains(mux.Bus, ":") {
+            invalid(key+".bus", "%q is a mux channel, muxes can't be chained", mux.Bus)
+        }
+        if mux.Address < tca9548a.DefaultAddr || mux.Address > tca9548a.DefaultAddr+7 {
+            invalid(key+".address", "0x%02x is not 0x70 to 0x77", mux.Address)
+        }
+        claim(key, "mux "+mux.Name, mux.Bus, mux.Address)
+    }
+
+    // checkBus checks a sensor's bus, if it is a mux channel
+    checkBus := func(key string, bus string) {
+        if !strings.Contains(bus, ":") {
+            return
+        }
+        name, channel, ok := MuxChannel(bus)
+        switch {
+        case !ok:
+            invalid(key+".bus", "%q is not a mux name and channel, such as mux:0", bus)
+        case !muxes[name]:
+            invalid(key+".bus", "no mux called %q in buses.muxes", name)
+        case channel < 0 || channel >= tca9548a.CHANNELS:
+            invalid(key+".bus", "mux channel %d is not 0 to %d", channel, tca9548a.CHANNELS-1)
+        }
+    }
+
+    names := make(map[string]bool)
+    for _, t := range sensorTypes {
+        key := "sensors." + t.Type
+        sensor, _ := c.Sensors.builtIn(t.Type)
+        names[t.Name] = true
+        if !sensor.Enabled {
+            continue
+        }
+        if sensor.Interval < time.Second {
+            invalid(key+".interval", "must be at least 1s, not %v", sensor.Interval)
+        }
+        if !hasAddress(t.Addresses, sensor.Address) {
+            invalid(key+".address", "0x%02x is not one of %s", sensor.Address, formatAddresses(t.Addresses))
+        }
+        checkBus(key, sensor.Bus)
+        claim(key, t.Name, sensor.Bus, sensor.Address)
+    }
+
+    instances := c.Sensors.Instances()
+    for i, extra := range instances[len(instances)-len(c.Sensors.Extra):] {
+        key := fmt.Sprintf("sensors.extra[%d]", i)
+
+        switch {
+        case extra.Name == "":
+            invalid(key+".name", "is empty")
+        case names[extra.Name]:
+            invalid(key+".name", "%q is already used", extra.Name)
+        }
+        names[extra.Name] = true
+
+        var addresses []uint16
+        for _, t := range sensorTypes {
+            if t.Type == extra.Type {
+                addresses = t.Addresses
+            }
+        }
+        if addresses == nil {
+            invalid(key+".type", "%q is not htu31d, pmsa003i or dps310", extra.Type)
+            continue
+        }
+
+        if extra.Interval < time.Second {
+            invalid(key+".interval", "must be at least 1s, not %v", extra.Interval)
+        }
+        if !hasAddress(addresses, extra.Address) {
+            invalid(key+".address", "0x%02x is not one of %s", extra.Address, formatAddresses(addresses))
+        }
+        checkBus(key, extra.Bus)
+        claim(key, extra.Name, extra.Bus, extra.Address)
+    }
+
+    if err := checkListen(c.Metrics.Listen); err != nil {
+        invalid("metrics.listen", "%v", err)
+    }
+    if c.API.Listen != "" {
+        if err := checkListen(c.API.Listen); err != nil {
+            invalid("api.listen", "%v", err)
+        }
+    }
+
+    switch c.Weather.Source {
+    case "hko":
+        if _, err := hko.ParseLanguage(c.Weather.HKO.Language); err != nil {
+            invalid("weather.hko.language", "%v", err)
+        }
+        if c.Weather.HKO.URL == "" {
+            invalid("weather.hko.url", "is empty")
+        }
+        if c.Weather.HKO.Timeout <= 0 {
+            invalid("weather.hko.timeout", "must be positive")
+        }
+    case "metar":
+        if len(c.Weather.METAR.Station) != 4 {
+            invalid("weather.metar.station", "%q is not a four letter ICAO code", c.Weather.METAR.Station)
+        }
+        if c.Weather.METAR.URL == "" {
+            invalid("weather.metar.url", "is empty")
+        }
+    case "none":
+    default:
+        invalid("weather.source", "%q is not hko, metar or none", c.Weather.Source)
+    }
+    if c.Weather.Source != "none" && c.Weather.Interval < time.Minute {
+        invalid("weather.interval", "must be at least 1m, not %v", c.Weather.Interval)
+    }
+
+    if _, ok := uc8159.PanelByName(c.Display.Panel); !ok {
+        invalid("display.panel", "%q is not 4, 5.7 or 7.3", c.Display.Panel)
+    }
+    switch c.Display.Rotation {
+    case 0, 90, 180, 270:
+    default:
+        invalid("display.rotation", "%d is not 0, 90, 180 or 270", c.Display.Rotation)
+    }
+    if c.Display.PageInterval < 0 {
+        invalid("display.page_interval", "must not be negative")
+    }
+    if c.Display.Enabled && len(c.Display.Pages) == 0 {
+        invalid("display.pages", "no pages to show")
+    }
+    for _, page := range c.Display.Pages {
+        if !hasString(screen.PageNames, page) {
+            invalid("display.pages", "unknown page %q", page)
+        }
+    }
+
+    if c.Storage.Retention <= 0 {
+        invalid("storage.retention", "must be positive")
+    }
+    if c.Storage.Path != "" && c.Storage.SaveInterval <= 0 {
+        invalid("storage.save_interval", "must be positive")
+    }
+
+    return errs.err()
+}
+
+func checkListen(addr string) error {
+    if addr == "" {
+        return errors.New("is empty")
+    }
+    _, _, err := net.SplitHostPort(addr)
+    return err
+}
+
+func hasAddress(addresses []uint16, addr uint16) bool {
+    for _, a := range addresses {
+        if a == addr {
+            return true
+        }
+    }
+    return false
+}
+
+func formatAddresses(addresses []uint16) string {
+    s := ""
+    for i, a := range addresses {
+        if i > 0 {
+            s += " or "
+        }
+        s += fmt.Sprintf("0x%02x", a)
+    }
+    return s
+}
+
+func hasString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
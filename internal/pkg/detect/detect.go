@@ -0,0 +1,109 @@
+// Package detect finds the sensor boards attached to an I2C bus by probing
+// the addresses each driver can sit at and checking the chip's identity, so
+// a board that happens to share an address isn't taken for a sensor.
+package detect
+
+import (
+    "fmt"
+
+    "periph.io/x/conn/v3/i2c"
+
+    "github.com/tony-tsang/airmon/internal/pkg/dps310"
+    "github.com/tony-tsang/airmon/internal/pkg/htu31"
+    "github.com/tony-tsang/airmon/internal/pkg/pmsa003i"
+)
+
+// Sensor is a driver that can be detected. Identify checks for the chip at
+// addr without changing its settings, and describes what it found.
+type Sensor struct {
+    Name      string
+    Addresses []uint16
+    Identify  func(bus i2c.Bus, addr uint16) (string, error)
+}
+
+// Sensors are the drivers known to airmon, probed in this order. New
+// drivers add themselves here.
+var Sensors = []Sensor{
+    {
+        Name:      "HTU31D",
+        Addresses: []uint16{htu31.DefaultAddr, 0x41},
+        Identify: func(bus i2c.Bus, addr uint16) (string, error) {
+            serial, err := htu31.Probe(bus, addr)
+            return fmt.Sprintf("serial %d", serial), err
+        },
+    },
+    {
+        Name:      "PMSA003I",
+        Addresses: []uint16{pmsa003i.PmSensorAddr},
+        Identify: func(bus i2c.Bus, addr uint16) (string, error) {
+            version, err := pmsa003i.Probe(bus, addr)
+            return fmt.Sprintf("version %d", version), err
+        },
+    },
+    {
+        Name:      "DPS310",
+        Addresses: []uint16{dps310.DefaultAddr, 0x76},
+        Identify: func(bus i2c.Bus, addr uint16) (string, error) {
+            id, err := dps310.Probe(bus, addr)
+            return fmt.Sprintf("product ID %#02x", id), err
+        },
+    },
+}
+
+// Result is what was found at one address. Err is why nothing was found:
+// nothing answered, or something other than the sensor did.
+type Result struct {
+    Sensor string
+    Addr   uint16
+    Detail string
+    Err    error
+}
+
+func (r Result) Found() bool {
+    return r.Err == nil
+}
+
+// Scan probes every address of every known sensor.
+func Scan(bus i2c.Bus) []Result {
+    var results []Result
+    for _, sensor := range Sensors {
+        for _, addr := range sensor.Addresses {
+            detail, err := sensor.Identify(bus, addr)
+            results = append(results, Result{Sensor: sensor.Name, Addr: addr, Detail: detail, Err: err})
+        }
+    }
+    return results
+}
+
+// Find returns where sensor was found in results, preferring preferred if
+// it was found at more than one address.
+func Find(results []Result, sensor string, preferred uint16) (Result, bool) {
+    var first Result
+    found := false
+    for _, r := range results {
+        if r.Sensor != sensor || !r.Found() {
+            continue
+        }
+        if r.Addr == preferred {
+            return r, true
+        }
+        if !found {
+            first, found = r, true
+        }
+    }
+    return first, found
+}
+
+// Responding lists every address that answers a one byte read, known or
+// not. Some chips don't answer reads like this, so it is only a guide.
+func Responding(bus i2c.Bus) []uint16 {
+    var addrs []uint16
+    buf := make([]byte, 1)
+    // 0x00-0x07 and 0x78-0x7f are reserved
+    for addr := uint16(0x08); addr < 0x78; addr++ {
+        if bus.Tx(addr, nil, buf) == nil {
+            addrs = append(addrs, addr)
+        }
+    }
+    return addrs
+}
@@ -1,13 +1,17 @@
 package dps310
 
 import (
+    "errors"
+    "fmt"
     "log"
     "periph.io/x/conn/v3/i2c"
     "time"
 )
 
 const (
-    sensorAddr = 0x77
+    // DefaultAddr is the address with SDO high, as on the Adafruit board.
+    // Pulling SDO low moves it to 0x76.
+    DefaultAddr uint16 = 0x77
 )
 
 const (
@@ -42,8 +46,20 @@ const (
     CONTINUOUS_TEMP_PRESSURE_MEASURE = 0b111
 )
 
+// PRODUCT_ID is the product and revision ID of the DPS310.
+const PRODUCT_ID = 0x10
+
+// ErrNotDPS310 is returned by Probe when something else answers, such as a
+// BMP280 sharing the address.
+var ErrNotDPS310 = errors.New("dps310: not a DPS310")
+
+// readyTimeout is how long to wait for the sensor to be ready before giving
+// up, so a missing sensor doesn't hang.
+const readyTimeout = 1 * time.Second
+
 type DPS310 struct {
     dev                   *i2c.Dev
+    err                   error
     overSampleScaleFactor []int32
     pressureScale         int32
     temperatureScale      int32
@@ -61,8 +77,13 @@ type DPS310 struct {
 }
 
 func New(i2cBus i2c.Bus) *DPS310 {
+    return NewAt(i2cBus, DefaultAddr)
+}
+
+// NewAt returns the sensor at addr, 0x76 or 0x77.
+func NewAt(i2cBus i2c.Bus, addr uint16) *DPS310 {
     d := new(DPS310)
-    d.dev = &i2c.Dev{Addr: sensorAddr, Bus: i2cBus}
+    d.dev = &i2c.Dev{Addr: addr, Bus: i2cBus}
     d.overSampleScaleFactor = []int32{
         524288,
         1572864,
@@ -114,6 +135,7 @@ func (d *DPS310) setRegisterBits(cmd, offset, length, val byte) {
     err := d.dev.Tx(outBuf, inBuf)
     if err != nil {
         log.Printf("Error writing to sensor %d\n", err)
+        d.fail(err)
     }
 
     bitmask := byte(0)
@@ -127,6 +149,7 @@ func (d *DPS310) setRegisterBits(cmd, offset, length, val byte) {
     err = d.dev.Tx(outBuf, nil)
     if err != nil {
         log.Printf("Error writing to sensor %d\n", err)
+        d.fail(err)
     }
 }
 
@@ -137,6 +160,7 @@ func (d *DPS310) getRegisterBits(cmd, offset, length byte) byte {
     err := d.dev.Tx(outBuf, inBuf)
     if err != nil {
         log.Printf("Error writing to sensor %d\n", err)
+        d.fail(err)
     }
 
     bitmask := byte(0)
@@ -164,6 +188,29 @@ func (d *DPS310) init() {
     d.waitPressureReady()
 }
 
+// Reset resets the sensor, reads its calibration again and restarts
+// continuous measurement, returning any error along the way.
+func (d *DPS310) Reset() error {
+    d.Err()
+    d.init()
+    return d.Err()
+}
+
+// Err returns the first error since the last call to Err, and clears it.
+// The other methods log errors and carry on, so a caller checks Err to know
+// whether what they returned can be trusted.
+func (d *DPS310) Err() error {
+    err := d.err
+    d.err = nil
+    return err
+}
+
+func (d *DPS310) fail(err error) {
+    if d.err == nil {
+        d.err = err
+    }
+}
+
 func (d *DPS310) GetProductRevID() byte {
     prodID := d.getRegisterBits(PRODREVID, 0, 8)
     return prodID
@@ -185,9 +232,7 @@ func (d *DPS310) reset() {
 
     time.Sleep(10 * time.Millisecond)
 
-    for !d.sensorReady() {
-        time.Sleep(1 * time.Millisecond)
-    }
+    d.waitFor("sensor", d.sensorReady)
 
     d.correctTemp()
     d.readCalibration()
@@ -201,13 +246,21 @@ func (d *DPS310) getMeasCfgRegister(bit uint8) uint8 {
 }
 
 func (d *DPS310) waitTemperatureReady() {
-    for d.getMeasCfgRegister(TMP_RDY) == 0 {
-        time.Sleep(1 * time.Millisecond)
-    }
+    d.waitFor("temperature", func() bool { return d.getMeasCfgRegister(TMP_RDY) == 1 })
 }
 
 func (d *DPS310) waitPressureReady() {
-    for d.getMeasCfgRegister(PRS_RDY) == 0 {
+    d.waitFor("pressure", func() bool { return d.getMeasCfgRegister(PRS_RDY) == 1 })
+}
+
+// waitFor polls ready every millisecond for up to readyTimeout.
+func (d *DPS310) waitFor(what string, ready func() bool) {
+    deadline := time.Now().Add(readyTimeout)
+    for !ready() {
+        if time.Now().After(deadline) {
+            d.fail(fmt.Errorf("dps310: timed out waiting for %s", what))
+            return
+        }
         time.Sleep(1 * time.Millisecond)
     }
 }
@@ -221,9 +274,7 @@ func twosComplement(val uint32, bits int) int32 {
 }
 
 func (d *DPS310) readCalibration() {
-    for !d.coefficientsReady() {
-        time.Sleep(1 * time.Millisecond)
-    }
+    d.waitFor("coefficients", d.coefficientsReady)
 
     coeffs := make([]byte, 18)
 
@@ -287,6 +338,7 @@ func (d *DPS310) rawTemperature() uint32 {
     err := d.dev.Tx(buffer, inBuf)
     if err != nil {
         log.Printf("Error reading / writing to sensor %d\n", err)
+        d.fail(err)
     }
 
     rawTemperature := uint32(inBuf[0])<<16 | uint32(inBuf[1])<<8 | uint32(inBuf[2])
@@ -300,6 +352,7 @@ func (d *DPS310) rawPressure() uint32 {
     err := d.dev.Tx(buffer, inBuf)
     if err != nil {
         log.Printf("Error reading / writing to sensor %d\n", err)
+        d.fail(err)
     }
 
     rawPressure := uint32(inBuf[0])<<16 | uint32(inBuf[1])<<8 | uint32(inBuf[2])
@@ -334,16 +387,17 @@ func (d *DPS310) GetTemperature() float64 {
     return temperature
 }
 
-func DoLoop(i2cBus i2c.Bus, channel chan TempPressure, sleep time.Duration) {
-
-    d := New(i2cBus)
-
-    for {
-        temperature := d.GetTemperature()
-        pressure := d.GetPressure()
-
-        channel <- TempPressure{Temp: temperature, Pressure: pressure}
+// Probe checks for a DPS310 at addr by reading its product ID, without
+// resetting it.
+func Probe(i2cBus i2c.Bus, addr uint16) (byte, error) {
+    inBuf := make([]byte, 1)
+    err := i2cBus.Tx(addr, []byte{PRODREVID}, inBuf)
+    if err != nil {
+        return 0, err
+    }
 
-        time.Sleep(sleep)
+    if inBuf[0] != PRODUCT_ID {
+        return inBuf[0], ErrNotDPS310
     }
+    return inBuf[0], nil
 }
@@ -0,0 +1,95 @@
+// Package health follows how each sensor's reads are going and decides when
+// to try to recover it: first by resetting the sensor, then, if that doesn't
+// help, by recovering the whole bus.
+package health
+
+import (
+    "time"
+)
+
+// Action is what the caller should do about a failing sensor.
+type Action int
+
+const (
+    // None means wait, either for more failures or for the backoff.
+    None Action = iota
+    // Reset means soft reset the sensor and read its calibration again.
+    Reset
+    // RecoverBus means clock out the bus and then reset the sensor.
+    RecoverBus
+)
+
+func (a Action) String() string {
+    switch a {
+    case Reset:
+        return "reset"
+    case RecoverBus:
+        return "bus"
+    }
+    return "none"
+}
+
+// Tracker counts one sensor's consecutive failures. After ResetAfter of
+// them it asks for a reset, and after BusAfter for a bus recovery. Once it
+// has asked, it waits Backoff before asking again, doubling up to
+// MaxBackoff, so a sensor that has gone for good isn't reset every read.
+type Tracker struct {
+    ResetAfter int
+    BusAfter   int
+    Backoff    time.Duration
+    MaxBackoff time.Duration
+
+    failures int
+    backoff  time.Duration
+    next     time.Time
+}
+
+func NewTracker() *Tracker {
+    return &Tracker{
+        ResetAfter: 3,
+        BusAfter:   10,
+        Backoff:    10 * time.Second,
+        MaxBackoff: 10 * time.Minute,
+    }
+}
+
+// Success records a good read, clearing the failures and the backoff.
+func (t *Tracker) Success() {
+    t.failures = 0
+    t.backoff = 0
+    t.next = time.Time{}
+}
+
+// Failure records a failed read at now and returns what to do about it.
+func (t *Tracker) Failure(now time.Time) Action {
+    t.failures++
+
+    if t.failures < t.ResetAfter || now.Before(t.next) {
+        return None
+    }
+
+    if t.backoff == 0 {
+        t.backoff = t.Backoff
+    } else {
+        t.backoff *= 2
+        if t.backoff > t.MaxBackoff {
+            t.backoff = t.MaxBackoff
+        }
+    }
+    t.next = now.Add(t.backoff)
+
+    if t.BusAfter > 0 && t.failures >= t.BusAfter {
+        return RecoverBus
+    }
+    return Reset
+}
+
+// Failures is the number of failed reads in a row.
+func (t *Tracker) Failures() int {
+    return t.failures
+}
+
+// Healthy reports whether the sensor is below the reset threshold.
+func (t *Tracker) Healthy() bool {
+    return t.failures < t.ResetAfter
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+    "encoding/json"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Save writes every series to path as JSON, so the charts survive a restart.
+// The file is replaced in one step, so a crash part way leaves the old one.
+func (st *Store) Save(path string) error {
+    st.mu.Lock()
+    names := make([]string, 0, len(st.series))
+    for name := range st.series {
+        names = append(names, name)
+    }
+    st.mu.Unlock()
+
+    saved := make(map[string][]Sample, len(names))
+    for _, name := range names {
+        saved[name] = st.Series(name).Range(time.Time{}, time.Now().Add(time.Hour))
+    }
+
+    body, err := json.Marshal(saved)
+    if err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    _, err = tmp.Write(body)
+    if err == nil {
+        err = tmp.Sync()
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return err
+    }
+
+    return os.Rename(tmp.Name(), path)
+}
+
+// Load adds the samples saved in path, dropping any past the retention
+// period. A missing file is not an error.
+func (st *Store) Load(path string) error {
+    body, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var saved map[string][]Sample
+    err = json.Unmarshal(body, &saved)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    for name, samples := range saved {
+        series := st.Series(name)
+        for _, sample := range samples {
+            series.Add(sample.Time, sample.Value)
+        }
+        series.mu.Lock()
+        series.expire(now)
+        series.mu.Unlock()
+    }
+
+    return nil
+}
@@ -0,0 +1,192 @@
+// Package history keeps recent readings in memory so they can be charted.
+package history
+
+import (
+    "math"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Series names used by airmon.
+const (
+    Temperature        = "temperature"
+    Humidity           = "humidity"
+    Pressure           = "pressure"
+    PM25               = "pm25"
+    OutdoorTemperature = "outdoor_temperature"
+    OutdoorHumidity    = "outdoor_humidity"
+    Rainfall           = "rainfall"
+)
+
+type Sample struct {
+    Time  time.Time
+    Value float64
+}
+
+// Bucket summarises the samples that fall in [Start, Start+step).
+type Bucket struct {
+    Start time.Time
+    Min   float64
+    Max   float64
+    Mean  float64
+    Sum   float64
+    Count int
+}
+
+// Series holds samples in time order, dropping those older than retention.
+type Series struct {
+    mu        sync.RWMutex
+    retention time.Duration
+    samples   []Sample
+}
+
+func NewSeries(retention time.Duration) *Series {
+    return &Series{retention: retention}
+}
+
+// Add records a sample. Samples are expected in time order; out of order
+// ones are inserted where they belong.
+func (s *Series) Add(t time.Time, value float64) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sample := Sample{t, value}
+
+    n := len(s.samples)
+    if n == 0 || !t.Before(s.samples[n-1].Time) {
+        s.samples = append(s.samples, sample)
+    } else {
+        i := sort.Search(n, func(i int) bool { return s.samples[i].Time.After(t) })
+        s.samples = append(s.samples, Sample{})
+        copy(s.samples[i+1:], s.samples[i:])
+        s.samples[i] = sample
+    }
+
+    s.expire(t)
+}
+
+func (s *Series) expire(now time.Time) {
+    if s.retention <= 0 {
+        return
+    }
+
+    cutoff := now.Add(-s.retention)
+    i := sort.Search(len(s.samples), func(i int) bool { return !s.samples[i].Time.Before(cutoff) })
+    if i > 0 {
+        s.samples = append(s.samples[:0], s.samples[i:]...)
+    }
+}
+
+// Range returns a copy of the samples in [from, to).
+func (s *Series) Range(from time.Time, to time.Time) []Sample {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    start := sort.Search(len(s.samples), func(i int) bool { return !s.samples[i].Time.Before(from) })
+    end := sort.Search(len(s.samples), func(i int) bool { return !s.samples[i].Time.Before(to) })
+
+    out := make([]Sample, end-start)
+    copy(out, s.samples[start:end])
+    return out
+}
+
+// Latest returns the most recent sample.
+func (s *Series) Latest() (Sample, bool) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if len(s.samples) == 0 {
+        return Sample{}, false
+    }
+    return s.samples[len(s.samples)-1], true
+}
+
+// Store is a set of named series sharing one retention period.
+type Store struct {
+    mu        sync.Mutex
+    retention time.Duration
+    series    map[string]*Series
+}
+
+func NewStore(retention time.Duration) *Store {
+    return &Store{retention: retention, series: make(map[string]*Series)}
+}
+
+// Series returns the named series, creating it if needed.
+func (st *Store) Series(name string) *Series {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    s, ok := st.series[name]
+    if !ok {
+        s = NewSeries(st.retention)
+        st.series[name] = s
+    }
+    return s
+}
+
+// SetRetention changes the retention period of every series. Samples past a
+// shorter period are dropped with the next sample added.
+func (st *Store) SetRetention(retention time.Duration) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    st.retention = retention
+    for _, s := range st.series {
+        s.mu.Lock()
+        s.retention = retention
+        s.mu.Unlock()
+    }
+}
+
+func (st *Store) Add(name string, t time.Time, value float64) {
+    st.Series(name).Add(t, value)
+}
+
+func (st *Store) Range(name string, from time.Time, to time.Time) []Sample {
+    return st.Series(name).Range(from, to)
+}
+
+// Buckets splits [from, from+n*step) into n buckets and summarises the
+// samples in each. Empty buckets have a Count of 0 and NaN statistics.
+func Buckets(samples []Sample, from time.Time, step time.Duration, n int) []Bucket {
+    buckets := make([]Bucket, n)
+    for i := range buckets {
+        buckets[i] = Bucket{
+            Start: from.Add(time.Duration(i) * step),
+            Min:   math.NaN(),
+            Max:   math.NaN(),
+            Mean:  math.NaN(),
+        }
+    }
+
+    if step <= 0 {
+        return buckets
+    }
+
+    for _, sample := range samples {
+        i := int(sample.Time.Sub(from) / step)
+        if i < 0 || i >= n {
+            continue
+        }
+
+        b := &buckets[i]
+        if b.Count == 0 || sample.Value < b.Min {
+            b.Min = sample.Value
+        }
+        if b.Count == 0 || sample.Value > b.Max {
+            b.Max = sample.Value
+        }
+        b.Sum += sample.Value
+        b.Count++
+    }
+
+    for i := range buckets {
+        if buckets[i].Count > 0 {
+            buckets[i].Mean = buckets[i].Sum / float64(buckets[i].Count)
+        }
+    }
+
+    return buckets
+}
@@ -0,0 +1,300 @@
+package hko
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const API_URL = "https://data.weather.gov.hk/weatherAPI/opendata/weather.php"
+
+// Datasets, the dataType parameter of the API.
+const (
+	CURRENT_WEATHER        = "rhrread"
+	LOCAL_WEATHER_FORECAST = "flw"
+	NINE_DAY_FORECAST      = "fnd"
+	WARNING_SUMMARY        = "warnsum"
+	WARNING_INFO           = "warningInfo"
+	SPECIAL_WEATHER_TIPS   = "swt"
+)
+
+// Language is the language of the text and place names in the datasets.
+type Language string
+
+const (
+	English            Language = "en"
+	TraditionalChinese Language = "tc"
+	SimplifiedChinese  Language = "sc"
+)
+
+func ParseLanguage(name string) (Language, error) {
+	switch lang := Language(name); lang {
+	case English, TraditionalChinese, SimplifiedChinese:
+		return lang, nil
+	}
+	return "", fmt.Errorf("unknown language %q, expected en, tc or sc", name)
+}
+
+// ErrNoData is returned when a dataset can't be fetched and there is no
+// earlier copy to fall back on.
+var ErrNoData = errors.New("hko: no data")
+
+// noDataError is ErrNoData along with why the fetch failed.
+type noDataError struct {
+	err error
+}
+
+func (e *noDataError) Error() string {
+	return ErrNoData.Error() + ": " + e.err.Error()
+}
+
+func (e *noDataError) Is(target error) bool {
+	return target == ErrNoData
+}
+
+func (e *noDataError) Unwrap() error {
+	return e.err
+}
+
+// StatusError is returned for an unexpected HTTP status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("hko: %s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// temporary reports whether the request is worth retrying.
+func (e *StatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client fetches datasets from the HKO open data API. Each attempt is given
+// Timeout, and failed attempts are retried up to Retries times, waiting
+// Backoff, then twice as long each time up to MaxBackoff.
+//
+// Responses are cached, so unchanged datasets are revalidated with their
+// ETag or Last-Modified time rather than downloaded again, and if a dataset
+// can't be fetched the last good copy is returned along with the error.
+type Client struct {
+	HTTP       *http.Client
+	BaseURL    string
+	Language   Language
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cached
+}
+
+type cached struct {
+	body         []byte
+	etag         string
+	lastModified string
+	fetched      time.Time
+}
+
+// NewClient returns a Client using httpClient, or http.DefaultClient if it is
+// nil.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		HTTP:       httpClient,
+		BaseURL:    API_URL,
+		Language:   TraditionalChinese,
+		Timeout:    15 * time.Second,
+		Retries:    3,
+		Backoff:    2 * time.Second,
+		MaxBackoff: 30 * time.Second,
+		cache:      make(map[string]*cached),
+	}
+}
+
+// DefaultClient is used by the package level functions.
+var DefaultClient = NewClient(nil)
+
+// Fetched says when a dataset was fetched. Stale is set when it is the last
+// good copy, returned because the latest fetch failed.
+type Fetched struct {
+	Time  time.Time
+	Stale bool
+}
+
+// get fetches and decodes dataset. If that fails but there is an earlier
+// copy, the earlier copy is returned as stale along with the error.
+func get[T any](ctx context.Context, c *Client, dataset string) (*T, Fetched, error) {
+	requestURL := c.url(dataset)
+
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = make(map[string]*cached)
+	}
+	previous := c.cache[requestURL]
+	c.mu.Unlock()
+
+	entry, err := c.fetch(ctx, requestURL, previous)
+	if err == nil {
+		data := new(T)
+		err = json.Unmarshal(entry.body, data)
+		if err == nil {
+			c.mu.Lock()
+			c.cache[requestURL] = entry
+			c.mu.Unlock()
+			return data, Fetched{Time: entry.fetched}, nil
+		}
+		err = fmt.Errorf("hko: decoding %s: %w", dataset, err)
+	}
+
+	if previous == nil {
+		return nil, Fetched{}, &noDataError{err}
+	}
+
+	// only copies that decoded are cached
+	data := new(T)
+	_ = json.Unmarshal(previous.body, data)
+	return data, Fetched{Time: previous.fetched, Stale: true}, err
+}
+
+func (c *Client) url(dataset string) string {
+	lang := c.Language
+	if lang == "" {
+		lang = TraditionalChinese
+	}
+
+	query := url.Values{}
+	query.Set("dataType", dataset)
+	query.Set("lang", string(lang))
+
+	return c.BaseURL + "?" + query.Encode()
+}
+
+// fetch requests requestURL, retrying failures that might be temporary.
+func (c *Client) fetch(ctx context.Context, requestURL string, previous *cached) (*cached, error) {
+	backoff := c.Backoff
+
+	for attempt := 0; ; attempt++ {
+		entry, err := c.request(ctx, requestURL, previous)
+		if err == nil {
+			return entry, nil
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.temporary() {
+			return nil, err
+		}
+
+		if attempt >= c.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+
+		backoff *= 2
+		if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) request(ctx context.Context, requestURL string, previous *cached) (*cached, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		if previous.etag != "" {
+			request.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			request.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified && previous != nil:
+		entry := *previous
+		entry.fetched = time.Now()
+		return &entry, nil
+
+	case response.StatusCode != http.StatusOK:
+		return nil, &StatusError{URL: requestURL, StatusCode: response.StatusCode}
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cached{
+		body:         body,
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+		fetched:      time.Now(),
+	}, nil
+}
+
+// CurrentWeather fetches the current weather report. The report is nil only
+// if there is an error and no earlier copy.
+func (c *Client) CurrentWeather(ctx context.Context) (*HKOCurrentWeather, Fetched, error) {
+	return get[HKOCurrentWeather](ctx, c, CURRENT_WEATHER)
+}
+
+// LocalWeatherForecast fetches the local weather forecast.
+func (c *Client) LocalWeatherForecast(ctx context.Context) (*HKOLocalWeatherForecast, Fetched, error) {
+	return get[HKOLocalWeatherForecast](ctx, c, LOCAL_WEATHER_FORECAST)
+}
+
+// NineDayForecast fetches the 9-day weather forecast.
+func (c *Client) NineDayForecast(ctx context.Context) (*HKONineDayForecast, Fetched, error) {
+	return get[HKONineDayForecast](ctx, c, NINE_DAY_FORECAST)
+}
+
+// WarningSummary fetches the summary of warnings in force.
+func (c *Client) WarningSummary(ctx context.Context) (*HKOWarningSummary, Fetched, error) {
+	return get[HKOWarningSummary](ctx, c, WARNING_SUMMARY)
+}
+
+// WarningInfo fetches the full text of the warnings in force.
+func (c *Client) WarningInfo(ctx context.Context) (*HKOWarningInfo, Fetched, error) {
+	return get[HKOWarningInfo](ctx, c, WARNING_INFO)
+}
+
+// SpecialWeatherTips fetches the special weather tips.
+func (c *Client) SpecialWeatherTips(ctx context.Context) (*HKOSpecialWeatherTips, Fetched, error) {
+	return get[HKOSpecialWeatherTips](ctx, c, SPECIAL_WEATHER_TIPS)
+}
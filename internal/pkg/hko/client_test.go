@@ -0,0 +1,295 @@
+package hko_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tony-tsang/airmon/internal/pkg/hko"
+	"github.com/tony-tsang/airmon/internal/pkg/hko/hkostub"
+)
+
+// live also checks the real API's responses still match our models:
+// go test ./internal/pkg/hko -run Live -args -live
+var live = flag.Bool("live", false, "also check the live HKO API against the models")
+
+var languages = []hko.Language{hko.English, hko.TraditionalChinese, hko.SimplifiedChinese}
+
+var fixtureDatasets = []string{hko.CURRENT_WEATHER, hko.LOCAL_WEATHER_FORECAST, hko.NINE_DAY_FORECAST}
+
+func newStub(t *testing.T) *hkostub.Server {
+	stub := hkostub.NewServer()
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// edit returns the fixture for dataset after change has been applied to it.
+func edit(t *testing.T, dataset string, lang hko.Language, change func(data map[string]any)) []byte {
+	t.Helper()
+
+	fixture, err := hkostub.Fixture(dataset, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data map[string]any
+	err = json.Unmarshal(fixture, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change(data)
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestFixturesMatchModels(t *testing.T) {
+	for _, dataset := range fixtureDatasets {
+		for _, lang := range languages {
+			fixture, err := hkostub.Fixture(dataset, lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = hko.CheckContract(dataset, fixture)
+			if err != nil {
+				t.Errorf("%s %s: %v", dataset, lang, err)
+			}
+		}
+	}
+
+	// and the check itself notices new fields
+	body := edit(t, hko.CURRENT_WEATHER, hko.English, func(data map[string]any) {
+		data["newField"] = 1
+	})
+	var contractErr *hko.ContractError
+	if err := hko.CheckContract(hko.CURRENT_WEATHER, body); !errors.As(err, &contractErr) {
+		t.Errorf("unknown field not reported, got %v", err)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	stub := newStub(t)
+
+	for _, lang := range languages {
+		client := stub.Client()
+		client.Language = lang
+
+		data, err := client.Weather(context.Background(), hko.DefaultPlaces)
+		if err != nil {
+			t.Fatalf("%s: %v", lang, err)
+		}
+
+		if data.TemperatureStation != hko.TEMPERATURE_STATION || data.CurrentTemperature != 26 {
+			t.Errorf("%s: temperature %d from %q", lang, data.CurrentTemperature, data.TemperatureStation)
+		}
+		if data.HumidityStation != hko.HUMIDITY_STATION || data.CurrentHumidity != 92 {
+			t.Errorf("%s: humidity %d from %q", lang, data.CurrentHumidity, data.HumidityStation)
+		}
+		if data.RainfallDistrict != hko.RAINFALL_DISTRICT || data.Rainfall != 5 {
+			t.Errorf("%s: rainfall %.0f from %q", lang, data.Rainfall, data.RainfallDistrict)
+		}
+		if len(data.NineDayForecast) != 9 || data.Forecast == "" || data.UpdateTime.IsZero() {
+			t.Errorf("%s: forecast missing", lang)
+		}
+		if data.Stale {
+			t.Errorf("%s: fresh data marked stale", lang)
+		}
+	}
+}
+
+func TestMissingStationFallsBackToNearest(t *testing.T) {
+	stub := newStub(t)
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{
+		Body: edit(t, hko.CURRENT_WEATHER, hko.TraditionalChinese, func(data map[string]any) {
+			temperature := data["temperature"].(map[string]any)
+			var kept []any
+			for _, reading := range temperature["data"].([]any) {
+				if reading.(map[string]any)["place"] != "荃灣城門谷" {
+					kept = append(kept, reading)
+				}
+			}
+			temperature["data"] = kept
+		}),
+	})
+
+	data, err := stub.Client().Weather(context.Background(), hko.DefaultPlaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.TemperatureStation != "tsuen-wan-ho-koon" || data.CurrentTemperature != 24 {
+		t.Errorf("temperature %d from %q, want 24 from tsuen-wan-ho-koon", data.CurrentTemperature, data.TemperatureStation)
+	}
+}
+
+func TestEmptyArrays(t *testing.T) {
+	stub := newStub(t)
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{
+		Body: []byte(`{"temperature":{"data":[]},"humidity":{"data":[]},"rainfall":{"data":[]},"icon":[],"uvindex":"","warningMessage":"","tcmessage":""}`),
+	})
+	stub.Set(hko.NINE_DAY_FORECAST, hkostub.Response{Body: []byte(`{"weatherForecast":[],"soilTemp":[]}`)})
+
+	data, err := stub.Client().Weather(context.Background(), hko.DefaultPlaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.TemperatureStation != "" || data.HumidityStation != "" || data.RainfallDistrict != "" || data.UVStation != "" {
+		t.Errorf("readings from %q %q %q %q with no data", data.TemperatureStation, data.HumidityStation, data.RainfallDistrict, data.UVStation)
+	}
+}
+
+func TestMalformedJSON(t *testing.T) {
+	stub := newStub(t)
+	client := stub.Client()
+
+	_, err := client.Weather(context.Background(), hko.DefaultPlaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{Body: []byte(`{"temperature":`)})
+
+	data, err := client.Weather(context.Background(), hko.DefaultPlaces)
+	if err == nil {
+		t.Error("no error for malformed JSON")
+	}
+	if !data.Stale || data.CurrentTemperature != 26 {
+		t.Errorf("last good data not returned: %+v", data)
+	}
+
+	_, err = stub.Client().Weather(context.Background(), hko.DefaultPlaces)
+	if !errors.Is(err, hko.ErrNoData) {
+		t.Errorf("got %v without earlier data, want ErrNoData", err)
+	}
+}
+
+func TestServerErrorIsRetried(t *testing.T) {
+	stub := newStub(t)
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{Status: http.StatusServiceUnavailable})
+
+	client := stub.Client()
+	_, err := client.Weather(context.Background(), hko.DefaultPlaces)
+
+	var statusErr *hko.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %v, want a 503 StatusError", err)
+	}
+	if n := stub.Requests(hko.CURRENT_WEATHER); n != client.Retries+1 {
+		t.Errorf("%d requests, want %d", n, client.Retries+1)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	stub := newStub(t)
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{Status: http.StatusNotFound})
+
+	_, err := stub.Client().Weather(context.Background(), hko.DefaultPlaces)
+	if err == nil {
+		t.Error("no error for 404")
+	}
+	if n := stub.Requests(hko.CURRENT_WEATHER); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestSlowResponsesTimeOut(t *testing.T) {
+	stub := newStub(t)
+	stub.Set(hko.CURRENT_WEATHER, hkostub.Response{Delay: 5 * time.Second})
+
+	client := stub.Client()
+	client.Timeout = 100 * time.Millisecond
+	client.Retries = 1
+
+	start := time.Now()
+	_, err := client.Weather(context.Background(), hko.DefaultPlaces)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("took %v to give up", elapsed)
+	}
+
+	// the caller's context is honoured too
+	client.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = client.Weather(ctx, hko.DefaultPlaces)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v with a cancelled context, want a timeout", err)
+	}
+}
+
+func TestUnchangedDataIsRevalidated(t *testing.T) {
+	stub := newStub(t)
+	client := stub.Client()
+
+	for i := 0; i < 2; i++ {
+		data, err := client.Weather(context.Background(), hko.DefaultPlaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a 304 has no body, so the second reading comes from the cache
+		if data.Stale || data.CurrentTemperature != 26 {
+			t.Fatalf("fetch %d returned %+v", i+1, data)
+		}
+	}
+
+	requests := stub.Log(hko.CURRENT_WEATHER)
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+
+	first, second := requests[0], requests[1]
+	if first.Status != http.StatusOK || first.IfNoneMatch != "" || first.ETag == "" {
+		t.Errorf("first request %+v, want a 200 with an ETag and no If-None-Match", first)
+	}
+	if second.IfNoneMatch != first.ETag {
+		t.Errorf("second request sent If-None-Match %q, want the ETag %q", second.IfNoneMatch, first.ETag)
+	}
+	if second.Status != http.StatusNotModified {
+		t.Errorf("second request got %d, want 304", second.Status)
+	}
+}
+
+func TestLiveAPIMatchesModels(t *testing.T) {
+	if !*live {
+		t.Skip("needs -live")
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	for dataset := range hko.Models {
+		for _, lang := range languages {
+			url := fmt.Sprintf("%s?dataType=%s&lang=%s", hko.API_URL, dataset, lang)
+			response, err := client.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := io.ReadAll(response.Body)
+			response.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = hko.CheckContract(dataset, body)
+			if err != nil {
+				t.Errorf("%s %s: %v", dataset, lang, err)
+			}
+		}
+	}
+}
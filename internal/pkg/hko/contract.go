@@ -0,0 +1,124 @@
+package hko
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Models maps each dataset to the type it is decoded into.
+var Models = map[string]reflect.Type{
+	CURRENT_WEATHER:        reflect.TypeOf(HKOCurrentWeather{}),
+	LOCAL_WEATHER_FORECAST: reflect.TypeOf(HKOLocalWeatherForecast{}),
+	NINE_DAY_FORECAST:      reflect.TypeOf(HKONineDayForecast{}),
+	WARNING_SUMMARY:        reflect.TypeOf(HKOWarningSummary{}),
+	WARNING_INFO:           reflect.TypeOf(HKOWarningInfo{}),
+	SPECIAL_WEATHER_TIPS:   reflect.TypeOf(HKOSpecialWeatherTips{}),
+}
+
+// ContractError lists the fields in a response that aren't in its model, so
+// would be silently dropped when decoding.
+type ContractError struct {
+	Dataset string
+	Unknown []string
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("hko: %s has unknown fields %s", e.Dataset, strings.Join(e.Unknown, ", "))
+}
+
+// CheckContract decodes data as dataset, returning a *ContractError if it has
+// fields the model doesn't.
+func CheckContract(dataset string, data []byte) error {
+	model, ok := Models[dataset]
+	if !ok {
+		return fmt.Errorf("hko: unknown dataset %q", dataset)
+	}
+
+	err := json.Unmarshal(data, reflect.New(model).Interface())
+	if err != nil {
+		return fmt.Errorf("hko: decoding %s: %w", dataset, err)
+	}
+
+	var raw any
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	var unknown []string
+	unknownFields(raw, model, dataset, &unknown)
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &ContractError{Dataset: dataset, Unknown: unknown}
+	}
+
+	return nil
+}
+
+// unknownFields walks the decoded JSON alongside the model. Values that
+// aren't objects or arrays where the model expects them, like the empty
+// strings the API sends for missing data, are left to the decoder.
+func unknownFields(raw any, model reflect.Type, path string, unknown *[]string) {
+	for model.Kind() == reflect.Pointer {
+		model = model.Elem()
+	}
+
+	switch value := raw.(type) {
+	case map[string]any:
+		switch model.Kind() {
+		case reflect.Map:
+			for name, field := range value {
+				unknownFields(field, model.Elem(), path+"."+name, unknown)
+			}
+
+		case reflect.Struct:
+			fields := jsonFields(model)
+			for name, field := range value {
+				fieldType, ok := fields[strings.ToLower(name)]
+				if !ok {
+					*unknown = append(*unknown, path+"."+name)
+					continue
+				}
+				unknownFields(field, fieldType, path+"."+name, unknown)
+			}
+		}
+
+	case []any:
+		if model.Kind() == reflect.Slice || model.Kind() == reflect.Array {
+			for i, element := range value {
+				unknownFields(element, model.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+			}
+		}
+	}
+}
+
+// jsonFields returns the types of a struct's fields by lower case JSON name,
+// since encoding/json matches names case-insensitively.
+func jsonFields(model reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tag, _, _ = strings.Cut(tag, ",")
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		fields[strings.ToLower(name)] = field.Type
+	}
+
+	return fields
+}
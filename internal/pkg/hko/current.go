@@ -0,0 +1,179 @@
+package hko
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Time is a timestamp in the ISO 8601 format used by the HKO open data API,
+// such as "2024-06-01T10:02:00+08:00". An empty string decodes to the zero
+// time.
+type Time struct {
+	time.Time
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	t.Time, err = time.Parse(time.RFC3339, s)
+	return err
+}
+
+// Messages is a list of messages. The API sends an empty string rather than
+// an empty list when there are none.
+type Messages []string
+
+func (m *Messages) UnmarshalJSON(data []byte) error {
+	if isEmpty(data) {
+		*m = nil
+		return nil
+	}
+
+	var messages []string
+	err := json.Unmarshal(data, &messages)
+	if err != nil {
+		return err
+	}
+
+	*m = messages
+	return nil
+}
+
+// isEmpty reports whether data is null or an empty string, which the API uses
+// in place of objects and lists that have no data.
+func isEmpty(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte(`""`))
+}
+
+type RainfallReading struct {
+	Unit  string  `json:"unit"`
+	Place string  `json:"place"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	// Main is "TRUE" while the district's gauges are under maintenance.
+	Main string `json:"main"`
+}
+
+func (r RainfallReading) UnderMaintenance() bool {
+	return strings.EqualFold(r.Main, "TRUE")
+}
+
+// Rainfall is the rainfall in each district over the past hour.
+type Rainfall struct {
+	Data      []RainfallReading `json:"data"`
+	StartTime Time              `json:"startTime"`
+	EndTime   Time              `json:"endTime"`
+}
+
+type LightningReading struct {
+	Place string `json:"place"`
+	Occur string `json:"occur"`
+}
+
+func (l LightningReading) Occurred() bool {
+	return strings.EqualFold(l.Occur, "true")
+}
+
+// Lightning is only reported while there is lightning about.
+type Lightning struct {
+	Data      []LightningReading `json:"data"`
+	StartTime Time               `json:"startTime"`
+	EndTime   Time               `json:"endTime"`
+}
+
+type UVReading struct {
+	Place   string  `json:"place"`
+	Value   float64 `json:"value"`
+	Desc    string  `json:"desc"`
+	Message string  `json:"message"`
+}
+
+// UVIndex is empty outside daylight hours.
+type UVIndex struct {
+	Data       []UVReading `json:"data"`
+	RecordDesc string      `json:"recordDesc"`
+}
+
+func (u *UVIndex) UnmarshalJSON(data []byte) error {
+	*u = UVIndex{}
+	if isEmpty(data) {
+		return nil
+	}
+
+	type plain UVIndex
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+type StationReading struct {
+	Place string `json:"place"`
+	Value int    `json:"value"`
+	Unit  string `json:"unit"`
+}
+
+// Readings are the values recorded at each station at RecordTime.
+type Readings struct {
+	Data       []StationReading `json:"data"`
+	RecordTime Time             `json:"recordTime"`
+}
+
+// At returns the value recorded at place.
+func (r Readings) At(place string) (int, bool) {
+	for _, reading := range r.Data {
+		if reading.Place == place {
+			return reading.Value, true
+		}
+	}
+	return 0, false
+}
+
+// HKOCurrentWeather is the current weather report, the rhrread dataset.
+type HKOCurrentWeather struct {
+	Lightning                  Lightning `json:"lightning"`
+	Rainfall                   Rainfall  `json:"rainfall"`
+	Icon                       []int     `json:"icon"`
+	IconUpdateTime             Time      `json:"iconUpdateTime"`
+	UVIndex                    UVIndex   `json:"uvindex"`
+	UpdateTime                 Time      `json:"updateTime"`
+	Temperature                Readings  `json:"temperature"`
+	Humidity                   Readings  `json:"humidity"`
+	WarningMessage             Messages  `json:"warningMessage"`
+	TCMessage                  Messages  `json:"tcmessage"`
+	SpecialWxTips              Messages  `json:"specialWxTips"`
+	RainstormReminder          string    `json:"rainstormReminder"`
+	MinTempFrom00To09          string    `json:"mintempFrom00To09"`
+	RainfallFrom00To12         string    `json:"rainfallFrom00To12"`
+	RainfallLastMonth          string    `json:"rainfallLastMonth"`
+	RainfallJanuaryToLastMonth string    `json:"rainfallJanuaryToLastMonth"`
+}
+
+// RainfallIn returns the past hour's rainfall in district.
+func (c *HKOCurrentWeather) RainfallIn(district string) (RainfallReading, bool) {
+	for _, reading := range c.Rainfall.Data {
+		if reading.Place == district {
+			return reading, true
+		}
+	}
+	return RainfallReading{}, false
+}
+
+// LightningIn reports whether there has been lightning over place.
+func (c *HKOCurrentWeather) LightningIn(place string) bool {
+	for _, reading := range c.Lightning.Data {
+		if reading.Place == place && reading.Occurred() {
+			return true
+		}
+	}
+	return false
+}
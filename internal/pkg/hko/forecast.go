@@ -0,0 +1,73 @@
+package hko
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// HongKong is the time zone of the dates in the API.
+var HongKong = time.FixedZone("HKT", 8*60*60)
+
+// Date is a day in the yyyymmdd format used by the forecasts.
+type Date struct {
+	time.Time
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	if s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+
+	d.Time, err = time.ParseInLocation("20060102", s, HongKong)
+	return err
+}
+
+type Value struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type ForecastDay struct {
+	Date    Date   `json:"forecastDate"`
+	Week    string `json:"week"`
+	Wind    string `json:"forecastWind"`
+	Weather string `json:"forecastWeather"`
+	MaxTemp Value  `json:"forecastMaxtemp"`
+	MinTemp Value  `json:"forecastMintemp"`
+	MaxRH   Value  `json:"forecastMaxrh"`
+	MinRH   Value  `json:"forecastMinrh"`
+	Icon    int    `json:"ForecastIcon"`
+	// PSR is the probability of significant rain, from "Low" to "High".
+	PSR string `json:"PSR"`
+}
+
+type SeaTemperature struct {
+	Place      string  `json:"place"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+	RecordTime Time    `json:"recordTime"`
+}
+
+type SoilTemperature struct {
+	Place      string  `json:"place"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+	RecordTime Time    `json:"recordTime"`
+	Depth      Value   `json:"depth"`
+}
+
+// HKONineDayForecast is the 9-day weather forecast, the fnd dataset.
+type HKONineDayForecast struct {
+	GeneralSituation string            `json:"generalSituation"`
+	WeatherForecast  []ForecastDay     `json:"weatherForecast"`
+	UpdateTime       Time              `json:"updateTime"`
+	SeaTemp          SeaTemperature    `json:"seaTemp"`
+	SoilTemp         []SoilTemperature `json:"soilTemp"`
+}
@@ -1,60 +1,44 @@
 package hko
 
 import (
-	"encoding/json"
+	"context"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 )
 
 type HKOData struct {
 	GeneralSituation   string
+	Forecast           string
+	Outlook            string
 	Warnings           string
 	CurrentTemperature int
 	CurrentHumidity    int
-	Rainfall           int
-}
-
-type HKOCurrentWeather struct {
-	Rainfall struct {
-		Data []struct {
-			Unit  string `json:"unit"`
-			Place string `json:"place"`
-			Max   int    `json:"max"`
-			Main  string `json:"main"`
-		} `json:"data"`
-		//StartTime string `json:"startTime"`
-		//EndTime   string `json:"endTime"`
-	} `json:"rainfall"`
-	WarningMessage []string `json:"warningMessage"`
-	Icon           []int    `json:"icon"`
-	UVIndex        string   `json:"uvindex"`
-	//UpdateTime     string   `json:"updateTime"`
-	Temperature struct {
-		Data []struct {
-			Place string `json:"place"`
-			Value int    `json:"value"`
-			Unit  string `json:"unit"`
-		} `json:"data"`
-		RecordTime string `json:"recordTime"`
-	} `json:"temperature"`
-	Humidity struct {
-		Data []struct {
-			Unit  string `json:"unit"`
-			Value int    `json:"value"`
-			Place string `json:"place"`
-		} `json:"data"`
-		RecordTime string `json:"recordTime"`
-	}
-	//TCMessage         string `json:"tcmessage"`
-	//MinTEmpFrom00To09 string `json`
+	Rainfall           float64
+	Icon               int
+	UVIndex            float64
+	UpdateTime         time.Time
+	// The keys of the places the readings are from, which may be the nearest
+	// to those asked for. UVStation is empty when there is no UV reading.
+	TemperatureStation string
+	HumidityStation    string
+	RainfallDistrict   string
+	UVStation          string
+	NineDayForecast    []ForecastDay
+	WarningSummary     HKOWarningSummary
+	WarningDetails     []WarningDetail
+	SpecialWeatherTips []string
+	// Fetched is when the current weather report was fetched, and Stale is
+	// set if any of the data is an earlier copy because the latest fetch
+	// failed.
+	Fetched time.Time
+	Stale   bool
 }
 
 type HKOLocalWeatherForecast struct {
 	GeneralSituation  string `json:"generalSituation"`
 	TCInfo            string `json:"tcInfo"`
-	FireDangerWanring string `json:"fireDangerWanring"`
+	FireDangerWarning string `json:"fireDangerWarning"`
 	ForecastPeriod    string `json:"forecastPeriod"`
 	ForecastDesc      string `json:"forecastDesc"`
 	Outlook           string `json:"outlook"`
@@ -62,65 +46,147 @@ type HKOLocalWeatherForecast struct {
 }
 
 const (
-	CURRENT_WEATHER        = "https://data.weather.gov.hk/weatherAPI/opendata/weather.php?dataType=rhrread&lang=tc"
-	LOCAL_WEATHER_FORECAST = "https://data.weather.gov.hk/weatherAPI/opendata/weather.php?dataType=flw&lang=tc"
+	// The default Places, near Tsuen Wan.
+	TEMPERATURE_STATION = "tsuen-wan-shing-mun-valley"
+	RAINFALL_DISTRICT   = "tsuen-wan"
+	HUMIDITY_STATION    = "hong-kong-observatory"
 )
 
-type GenericChan[T any] chan T
+// Weather fetches the current weather report along with the forecasts and
+// warnings, and picks out the readings for places. If any of them can't be
+// fetched, the first error is returned along with what could be, which is
+// marked Stale if it includes an earlier copy. The data is only zero if there
+// is no current weather report at all.
+func (c *Client) Weather(ctx context.Context, places Places) (HKOData, error) {
+
+	currentWeather, currentFetched, err := c.CurrentWeather(ctx)
+	if currentWeather == nil {
+		return HKOData{}, err
+	}
 
-func sendRequest[T any](url string, channel chan T) {
-	response, err := http.Get(url)
+	stale := currentFetched.Stale
+	keep := func(fetched Fetched, fetchErr error) {
+		stale = stale || fetched.Stale
+		if err == nil {
+			err = fetchErr
+		}
+	}
 
-	if err != nil {
-		log.Printf("Error making request to %s: %v", url, err)
+	localWeatherForecast, fetched, fetchErr := c.LocalWeatherForecast(ctx)
+	keep(fetched, fetchErr)
+	if localWeatherForecast == nil {
+		localWeatherForecast = &HKOLocalWeatherForecast{}
 	}
 
-	var responseData T
-	err = json.NewDecoder(response.Body).Decode(&responseData)
+	data := currentWeather.data(places, localWeatherForecast)
+	data.Fetched = currentFetched.Time
 
-	if err != nil {
-		log.Printf("Error decoding json data: %v", err)
+	nineDayForecast, fetched, fetchErr := c.NineDayForecast(ctx)
+	keep(fetched, fetchErr)
+	if nineDayForecast != nil {
+		data.NineDayForecast = nineDayForecast.WeatherForecast
 	}
 
-	response.Body.Close()
+	warningSummary, fetched, fetchErr := c.WarningSummary(ctx)
+	keep(fetched, fetchErr)
+	if warningSummary != nil {
+		data.WarningSummary = *warningSummary
+	}
+
+	warningInfo, fetched, fetchErr := c.WarningInfo(ctx)
+	keep(fetched, fetchErr)
+	if warningInfo != nil {
+		data.WarningDetails = warningInfo.Details
+	}
+
+	specialWeatherTips, fetched, fetchErr := c.SpecialWeatherTips(ctx)
+	keep(fetched, fetchErr)
+	if specialWeatherTips != nil {
+		for _, tip := range specialWeatherTips.Tips {
+			data.SpecialWeatherTips = append(data.SpecialWeatherTips, tip.Desc)
+		}
+	}
+
+	data.Stale = stale
+
+	return data, err
+}
 
-	channel <- responseData
+// Places returns the places in the latest current weather report.
+func (c *Client) Places(ctx context.Context) (PlaceList, error) {
+	currentWeather, _, err := c.CurrentWeather(ctx)
+	if currentWeather == nil {
+		return PlaceList{}, err
+	}
+	return currentWeather.Places(c.Language), err
+}
 
-	close(channel)
+// FetchPlaces returns the places in the latest current weather report.
+func FetchPlaces() (PlaceList, error) {
+	return DefaultClient.Places(context.Background())
 }
 
-func FetchWeather() HKOData {
+func FetchWeather(places Places) (HKOData, error) {
+	return DefaultClient.Weather(context.Background(), places)
+}
 
-	currentWeatherChan := make(chan HKOCurrentWeather)
-	go sendRequest(CURRENT_WEATHER, currentWeatherChan)
-	currentWeather := <-currentWeatherChan
+func (currentWeather *HKOCurrentWeather) data(places Places, localWeatherForecast *HKOLocalWeatherForecast) HKOData {
 
-	localWeatherForecastChan := make(chan HKOLocalWeatherForecast)
-	go sendRequest(LOCAL_WEATHER_FORECAST, localWeatherForecastChan)
-	localWeatherForecast := <-localWeatherForecastChan
+	var data HKOData
 
-	var currentTemperature int
+	if reading, ok := currentWeather.Temperature.Reading(places.TemperatureStation); ok {
+		if !SameStation(reading.Place, places.TemperatureStation) {
+			log.Printf("No temperature from %s, using %s", places.TemperatureStation, reading.Place)
+		}
+		data.CurrentTemperature = reading.Value
+		data.TemperatureStation = placeKey(Stations, reading.Place)
+	}
 
-	for _, weather := range currentWeather.Temperature.Data {
-		if weather.Place == "荃灣城門谷" {
-			currentTemperature = weather.Value
+	if reading, ok := currentWeather.Humidity.Reading(places.HumidityStation); ok {
+		if !SameStation(reading.Place, places.HumidityStation) {
+			log.Printf("No humidity from %s, using %s", places.HumidityStation, reading.Place)
 		}
+		data.CurrentHumidity = reading.Value
+		data.HumidityStation = placeKey(Stations, reading.Place)
 	}
 
-	return HKOData{
-		Warnings:           strings.Join(currentWeather.WarningMessage, "\n"),
-		GeneralSituation:   localWeatherForecast.GeneralSituation,
-		CurrentTemperature: currentTemperature,
-		CurrentHumidity:    currentWeather.Humidity.Data[0].Value,
+	if reading, ok := currentWeather.NearestRainfall(places.RainfallDistrict); ok {
+		if !SameDistrict(reading.Place, places.RainfallDistrict) {
+			log.Printf("No rainfall from %s, using %s", places.RainfallDistrict, reading.Place)
+		}
+		data.Rainfall = reading.Max
+		data.RainfallDistrict = placeKey(Districts, reading.Place)
 	}
+
+	if len(currentWeather.Icon) > 0 {
+		data.Icon = currentWeather.Icon[0]
+	}
+
+	if len(currentWeather.UVIndex.Data) > 0 {
+		data.UVIndex = currentWeather.UVIndex.Data[0].Value
+		data.UVStation = placeKey(Stations, currentWeather.UVIndex.Data[0].Place)
+	}
+
+	data.Warnings = strings.Join(currentWeather.WarningMessage, "\n")
+	data.GeneralSituation = localWeatherForecast.GeneralSituation
+	data.Forecast = localWeatherForecast.ForecastDesc
+	data.Outlook = localWeatherForecast.Outlook
+	data.UpdateTime = currentWeather.UpdateTime.Time
+
+	return data
 }
 
-func DoLoop(weatherInfo chan HKOData, sleep time.Duration) {
+func DoLoop(weatherInfo chan HKOData, sleep time.Duration, places Places) {
 
 	for {
 
-		hkoData := FetchWeather()
-		weatherInfo <- hkoData
+		hkoData, err := FetchWeather(places)
+		if err != nil {
+			log.Printf("Error fetching HKO weather: %v", err)
+		}
+		if !hkoData.Fetched.IsZero() {
+			weatherInfo <- hkoData
+		}
 
 		time.Sleep(sleep)
 	}
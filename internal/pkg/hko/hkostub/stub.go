@@ -0,0 +1,172 @@
+// Package hkostub is a stand-in for the HKO open data API, serving sample
+// payloads from fixtures/ and failures on demand, so the hko client can be
+// exercised without the network.
+package hkostub
+
+import (
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/tony-tsang/airmon/internal/pkg/hko"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Fixture returns the sample payload for dataset in lang, or an error
+// wrapping fs.ErrNotExist if there is none.
+func Fixture(dataset string, lang hko.Language) ([]byte, error) {
+	return fixtures.ReadFile(fmt.Sprintf("fixtures/%s-%s.json", dataset, lang))
+}
+
+// Response overrides what is served for a dataset. A zero Status means 200,
+// and a nil Body means the fixture. The response is sent after Delay, or
+// once the request is cancelled.
+type Response struct {
+	Status int
+	Body   []byte
+	Delay  time.Duration
+}
+
+// Request is one request the stub answered.
+type Request struct {
+	IfNoneMatch string
+	Status      int
+	ETag        string
+}
+
+// Server serves the API at its URL. Responses carry an ETag, and requests
+// with a matching If-None-Match get 304 Not Modified. Datasets without a
+// fixture are served as an empty object, as the API does when there are no
+// warnings or tips.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]Response
+	requests  map[string][]Request
+}
+
+func NewServer() *Server {
+	s := &Server{
+		responses: make(map[string]Response),
+		requests:  make(map[string][]Request),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Set overrides the response for dataset in every language until Reset.
+func (s *Server) Set(dataset string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[dataset] = response
+}
+
+// Reset goes back to serving the fixtures and clears the request counts.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses = make(map[string]Response)
+	s.requests = make(map[string][]Request)
+}
+
+// Requests returns how many times dataset has been requested.
+func (s *Server) Requests(dataset string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.requests[dataset])
+}
+
+// Log returns the requests for dataset that were answered, in order. Those
+// cancelled while delayed aren't included.
+func (s *Server) Log(dataset string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var answered []Request
+	for _, r := range s.requests[dataset] {
+		if r.Status != 0 {
+			answered = append(answered, r)
+		}
+	}
+	return answered
+}
+
+// answered records how the n'th request for dataset was answered.
+func (s *Server) answered(dataset string, n int, status int, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n < len(s.requests[dataset]) {
+		s.requests[dataset][n].Status = status
+		s.requests[dataset][n].ETag = etag
+	}
+}
+
+// Client returns a client for the stub that retries without waiting long.
+func (s *Server) Client() *hko.Client {
+	client := hko.NewClient(s.Server.Client())
+	client.BaseURL = s.URL
+	client.Timeout = time.Second
+	client.Backoff = 10 * time.Millisecond
+	client.MaxBackoff = 50 * time.Millisecond
+	return client
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	dataset := r.URL.Query().Get("dataType")
+	lang := hko.Language(r.URL.Query().Get("lang"))
+
+	s.mu.Lock()
+	n := len(s.requests[dataset])
+	s.requests[dataset] = append(s.requests[dataset], Request{IfNoneMatch: r.Header.Get("If-None-Match")})
+	response, overridden := s.responses[dataset]
+	s.mu.Unlock()
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if response.Status != 0 && response.Status != http.StatusOK {
+		s.answered(dataset, n, response.Status, "")
+		http.Error(w, http.StatusText(response.Status), response.Status)
+		return
+	}
+
+	body := response.Body
+	if !overridden || body == nil {
+		var err error
+		body, err = Fixture(dataset, lang)
+		if err != nil {
+			body = []byte("{}")
+		}
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		s.answered(dataset, n, http.StatusNotModified, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	s.answered(dataset, n, http.StatusOK, etag)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
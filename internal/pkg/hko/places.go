@@ -0,0 +1,301 @@
+package hko
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Places picks the readings for HKOData out of the current weather report.
+// Each place is a Place key, or its name in any language. If a place is
+// missing from a report, the nearest one that is there is used instead.
+type Places struct {
+	TemperatureStation string
+	HumidityStation    string
+	RainfallDistrict   string
+}
+
+var DefaultPlaces = Places{
+	TemperatureStation: TEMPERATURE_STATION,
+	HumidityStation:    HUMIDITY_STATION,
+	RainfallDistrict:   RAINFALL_DISTRICT,
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Place is a station or district. The API names places in the language of
+// the request, so they are matched on Key, which is the same in every
+// language.
+type Place struct {
+	Key                string
+	English            string
+	TraditionalChinese string
+	SimplifiedChinese  string
+	Location
+}
+
+func (p Place) Name(lang Language) string {
+	switch lang {
+	case English:
+		return p.English
+	case SimplifiedChinese:
+		return p.SimplifiedChinese
+	}
+	return p.TraditionalChinese
+}
+
+func (p Place) is(name string) bool {
+	return name == p.Key || strings.EqualFold(name, p.English) || name == p.TraditionalChinese || name == p.SimplifiedChinese
+}
+
+// key makes a Key from the English name, e.g. "tsuen-wan-shing-mun-valley".
+func key(english string) string {
+	english = strings.ToLower(strings.ReplaceAll(english, "'", ""))
+	return strings.Join(strings.FieldsFunc(english, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}), "-")
+}
+
+func place(english string, tc string, sc string, latitude float64, longitude float64) Place {
+	return Place{
+		Key:                key(english),
+		English:            english,
+		TraditionalChinese: tc,
+		SimplifiedChinese:  sc,
+		Location:           Location{latitude, longitude},
+	}
+}
+
+// Stations are where the temperature and humidity readings are taken.
+var Stations = []Place{
+	place("King's Park", "京士柏", "京士柏", 22.3119, 114.1728),
+	place("Hong Kong Observatory", "香港天文台", "香港天文台", 22.3019, 114.1742),
+	place("Wong Chuk Hang", "黃竹坑", "黄竹坑", 22.2478, 114.1736),
+	place("Ta Kwu Ling", "打鼓嶺", "打鼓岭", 22.5286, 114.1567),
+	place("Lau Fau Shan", "流浮山", "流浮山", 22.4689, 113.9836),
+	place("Tai Po", "大埔", "大埔", 22.4461, 114.1789),
+	place("Sha Tin", "沙田", "沙田", 22.4025, 114.2100),
+	place("Tuen Mun", "屯門", "屯门", 22.3858, 113.9642),
+	place("Tseung Kwan O", "將軍澳", "将军澳", 22.3158, 114.2556),
+	place("Sai Kung", "西貢", "西贡", 22.3758, 114.2744),
+	place("Cheung Chau", "長洲", "长洲", 22.2011, 114.0267),
+	place("Chek Lap Kok", "赤鱲角", "赤鱲角", 22.3094, 113.9219),
+	place("Tsing Yi", "青衣", "青衣", 22.3442, 114.1103),
+	place("Shek Kong", "石崗", "石岗", 22.4361, 114.0847),
+	place("Tsuen Wan Ho Koon", "荃灣可觀", "荃湾可观", 22.3836, 114.1078),
+	place("Tsuen Wan Shing Mun Valley", "荃灣城門谷", "荃湾城门谷", 22.3758, 114.1267),
+	place("Hong Kong Park", "香港公園", "香港公园", 22.2783, 114.1622),
+	place("Shau Kei Wan", "筲箕灣", "筲箕湾", 22.2817, 114.2361),
+	place("Kowloon City", "九龍城", "九龙城", 22.3350, 114.1847),
+	place("Happy Valley", "跑馬地", "跑马地", 22.2706, 114.1836),
+	place("Wong Tai Sin", "黃大仙", "黄大仙", 22.3394, 114.2053),
+	place("Stanley", "赤柱", "赤柱", 22.2142, 114.2186),
+	place("Kwun Tong", "觀塘", "观塘", 22.3186, 114.2247),
+	place("Sham Shui Po", "深水埗", "深水埗", 22.3358, 114.1369),
+	place("Kai Tak Runway Park", "啓德跑道公園", "启德跑道公园", 22.3047, 114.2169),
+	place("Yuen Long Park", "元朗公園", "元朗公园", 22.4408, 114.0183),
+	place("Tai Mei Tuk", "大美督", "大美督", 22.4753, 114.2375),
+}
+
+// Districts are the areas rainfall is reported for, located at roughly their
+// centre.
+var Districts = []Place{
+	place("Central & Western District", "中西區", "中西区", 22.2820, 114.1500),
+	place("Wan Chai", "灣仔", "湾仔", 22.2770, 114.1750),
+	place("Eastern District", "東區", "东区", 22.2790, 114.2250),
+	place("Southern District", "南區", "南区", 22.2400, 114.1900),
+	place("Yau Tsim Mong", "油尖旺", "油尖旺", 22.3120, 114.1700),
+	place("Sham Shui Po", "深水埗", "深水埗", 22.3300, 114.1600),
+	place("Kowloon City", "九龍城", "九龙城", 22.3200, 114.1900),
+	place("Wong Tai Sin", "黃大仙", "黄大仙", 22.3420, 114.1950),
+	place("Kwun Tong", "觀塘", "观塘", 22.3130, 114.2260),
+	place("Kwai Tsing", "葵青", "葵青", 22.3500, 114.1100),
+	place("Tsuen Wan", "荃灣", "荃湾", 22.3700, 114.1100),
+	place("Tuen Mun", "屯門", "屯门", 22.3900, 113.9700),
+	place("Yuen Long", "元朗", "元朗", 22.4450, 114.0220),
+	place("North District", "北區", "北区", 22.5000, 114.1500),
+	place("Tai Po", "大埔", "大埔", 22.4500, 114.1650),
+	place("Sha Tin", "沙田", "沙田", 22.3800, 114.1900),
+	place("Sai Kung", "西貢", "西贡", 22.3800, 114.2700),
+	place("Islands District", "離島區", "离岛区", 22.2600, 113.9500),
+}
+
+// findPlace looks name up by key or by name in any language.
+func findPlace(places []Place, name string) (Place, bool) {
+	for _, p := range places {
+		if p.is(name) {
+			return p, true
+		}
+	}
+	return Place{}, false
+}
+
+// placeKey returns the key of the named place, or the name itself if it
+// isn't one we know.
+func placeKey(places []Place, name string) string {
+	if p, ok := findPlace(places, name); ok {
+		return p.Key
+	}
+	return name
+}
+
+// distance is in kilometres, near enough over the size of Hong Kong.
+func (l Location) distance(other Location) float64 {
+	const kmPerDegree = 111.2
+
+	dLat := l.Latitude - other.Latitude
+	dLon := (l.Longitude - other.Longitude) * math.Cos(l.Latitude*math.Pi/180)
+	return math.Hypot(dLat, dLon) * kmPerDegree
+}
+
+// nearest returns the index of the candidate closest to the wanted place, or
+// of the first candidate if the wanted place or none of the candidates have
+// a known location. Candidates are names as reported by the API.
+func nearest(wanted string, candidates []string, places []Place) (int, bool) {
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	wantedKey := placeKey(places, wanted)
+	for i, candidate := range candidates {
+		if placeKey(places, candidate) == wantedKey {
+			return i, true
+		}
+	}
+
+	origin, ok := findPlace(places, wanted)
+	if !ok {
+		return 0, true
+	}
+
+	best, bestDistance := 0, math.Inf(1)
+	for i, candidate := range candidates {
+		p, ok := findPlace(places, candidate)
+		if !ok {
+			continue
+		}
+		if d := origin.distance(p.Location); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+
+	return best, true
+}
+
+// SameStation reports whether a and b, each a key or a name in any
+// language, are the same station.
+func SameStation(a string, b string) bool {
+	return placeKey(Stations, a) == placeKey(Stations, b)
+}
+
+// SameDistrict reports whether a and b are the same district.
+func SameDistrict(a string, b string) bool {
+	return placeKey(Districts, a) == placeKey(Districts, b)
+}
+
+// Reading returns the reading at place, or at the nearest station that has
+// one.
+func (r Readings) Reading(place string) (StationReading, bool) {
+	places := make([]string, len(r.Data))
+	for i, reading := range r.Data {
+		places[i] = reading.Place
+	}
+
+	i, ok := nearest(place, places, Stations)
+	if !ok {
+		return StationReading{}, false
+	}
+	return r.Data[i], true
+}
+
+// NearestRainfall returns the rainfall in district, or in the nearest district
+// whose gauges aren't under maintenance.
+func (c *HKOCurrentWeather) NearestRainfall(district string) (RainfallReading, bool) {
+	var readings []RainfallReading
+	var places []string
+
+	for _, reading := range c.Rainfall.Data {
+		if reading.UnderMaintenance() {
+			continue
+		}
+		readings = append(readings, reading)
+		places = append(places, reading.Place)
+	}
+
+	i, ok := nearest(district, places, Districts)
+	if !ok {
+		return RainfallReading{}, false
+	}
+	return readings[i], true
+}
+
+// PlaceList is every place a current weather report has readings for.
+// Places we don't know about have only the name in the report's language,
+// and no Key.
+type PlaceList struct {
+	TemperatureStations []Place
+	HumidityStations    []Place
+	RainfallDistricts   []Place
+}
+
+func reported(places []Place, name string, lang Language) Place {
+	if p, ok := findPlace(places, name); ok {
+		return p
+	}
+
+	var p Place
+	switch lang {
+	case English:
+		p.English = name
+	case SimplifiedChinese:
+		p.SimplifiedChinese = name
+	default:
+		p.TraditionalChinese = name
+	}
+	return p
+}
+
+// Places lists the places in the report, which is in lang.
+func (c *HKOCurrentWeather) Places(lang Language) PlaceList {
+	var list PlaceList
+
+	for _, reading := range c.Temperature.Data {
+		list.TemperatureStations = append(list.TemperatureStations, reported(Stations, reading.Place, lang))
+	}
+	for _, reading := range c.Humidity.Data {
+		list.HumidityStations = append(list.HumidityStations, reported(Stations, reading.Place, lang))
+	}
+	for _, reading := range c.Rainfall.Data {
+		list.RainfallDistricts = append(list.RainfallDistricts, reported(Districts, reading.Place, lang))
+	}
+
+	return list
+}
+
+// KnownPlaces lists the places the nearest station lookup knows about, for
+// when the Observatory can't be reached.
+func KnownPlaces() PlaceList {
+	return PlaceList{
+		TemperatureStations: Stations,
+		HumidityStations:    Stations,
+		RainfallDistricts:   Districts,
+	}
+}
+
+// Validate checks each place is one the nearest station lookup knows about,
+// since otherwise a missing reading falls back to an arbitrary station.
+func (p Places) Validate() error {
+	if _, ok := findPlace(Stations, p.TemperatureStation); !ok {
+		return fmt.Errorf("unknown temperature station %q", p.TemperatureStation)
+	}
+	if _, ok := findPlace(Stations, p.HumidityStation); !ok {
+		return fmt.Errorf("unknown humidity station %q", p.HumidityStation)
+	}
+	if _, ok := findPlace(Districts, p.RainfallDistrict); !ok {
+		return fmt.Errorf("unknown rainfall district %q", p.RainfallDistrict)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package hko
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tony-tsang/airmon/internal/pkg"
+	"github.com/tony-tsang/airmon/internal/pkg/weather"
+)
+
+// Provider is the weather.Provider for the Hong Kong Observatory.
+type Provider struct {
+	Client *Client
+	Places Places
+}
+
+func NewProvider(client *Client, places Places) *Provider {
+	if client == nil {
+		client = DefaultClient
+	}
+	return &Provider{Client: client, Places: places}
+}
+
+func (p *Provider) Name() string {
+	return "hko"
+}
+
+func (p *Provider) Fetch(ctx context.Context) (weather.Report, error) {
+	data, err := p.Client.Weather(ctx, p.Places)
+	if data.Fetched.IsZero() {
+		return weather.Report{}, err
+	}
+	return data.Report(), err
+}
+
+// Report converts the data to a weather.Report.
+func (d *HKOData) Report() weather.Report {
+	report := weather.Report{
+		Source:             "hko",
+		Observed:           d.UpdateTime,
+		Fetched:            d.Fetched,
+		Stale:              d.Stale,
+		Icon:               d.Icon,
+		GeneralSituation:   d.GeneralSituation,
+		Forecast:           d.Forecast,
+		Outlook:            d.Outlook,
+		SpecialWeatherTips: d.SpecialWeatherTips,
+	}
+
+	if d.TemperatureStation != "" {
+		report.Temperature = weather.Reading{Place: d.TemperatureStation, Value: float64(d.CurrentTemperature)}
+	}
+	if d.HumidityStation != "" {
+		report.Humidity = weather.Reading{Place: d.HumidityStation, Value: float64(d.CurrentHumidity)}
+	}
+	if d.RainfallDistrict != "" {
+		report.Rainfall = weather.Reading{Place: d.RainfallDistrict, Value: d.Rainfall}
+	}
+	if d.UVStation != "" {
+		report.UVIndex = weather.Reading{Place: d.UVStation, Value: d.UVIndex}
+	}
+
+	if d.Warnings != "" {
+		report.Messages = strings.Split(d.Warnings, "\n")
+	}
+
+	for _, code := range d.WarningSummary.Codes() {
+		warning := d.WarningSummary[code]
+
+		var details []string
+		for _, detail := range d.WarningDetails {
+			if detail.WarningStatementCode == code {
+				details = append(details, detail.Contents...)
+			}
+		}
+
+		report.Warnings = append(report.Warnings, weather.Warning{
+			Code:    code,
+			Subtype: warning.Code,
+			Name:    warning.Name,
+			Details: strings.Join(details, "\n"),
+		})
+	}
+
+	for _, day := range d.NineDayForecast {
+		report.DailyForecast = append(report.DailyForecast, pkg.DayForecast{
+			Date:    day.Date.Time,
+			Icon:    day.Icon,
+			MinTemp: day.MinTemp.Value,
+			MaxTemp: day.MaxTemp.Value,
+			Weather: day.Weather,
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,63 @@
+package hko
+
+import (
+	"sort"
+)
+
+// Warning is a warning or signal in force. Code is the subtype, such as
+// "WRAINA" for the amber rainstorm signal, within the warning statement it
+// is keyed by in the summary.
+type Warning struct {
+	Name       string `json:"name"`
+	Code       string `json:"code"`
+	Type       string `json:"type"`
+	ActionCode string `json:"actionCode"`
+	IssueTime  Time   `json:"issueTime"`
+	ExpireTime Time   `json:"expireTime"`
+	UpdateTime Time   `json:"updateTime"`
+}
+
+// Cancelled reports whether the warning is in the summary only because it
+// was just cancelled.
+func (w Warning) Cancelled() bool {
+	return w.ActionCode == "CANCEL"
+}
+
+// HKOWarningSummary is the warnsum dataset, keyed by warning statement code
+// such as "WRAIN" or "WTCSGNL".
+type HKOWarningSummary map[string]Warning
+
+// Codes returns the warning statement codes in force, sorted.
+func (s HKOWarningSummary) Codes() []string {
+	var codes []string
+	for code, warning := range s {
+		if !warning.Cancelled() {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+type WarningDetail struct {
+	Contents             []string `json:"contents"`
+	WarningStatementCode string   `json:"warningStatementCode"`
+	Subtype              string   `json:"subtype"`
+	UpdateTime           Time     `json:"updateTime"`
+}
+
+// HKOWarningInfo is the full text of the warnings in force, the warningInfo
+// dataset.
+type HKOWarningInfo struct {
+	Details []WarningDetail `json:"details"`
+}
+
+type SpecialWeatherTip struct {
+	Desc       string `json:"desc"`
+	UpdateTime Time   `json:"updateTime"`
+}
+
+// HKOSpecialWeatherTips is the swt dataset.
+type HKOSpecialWeatherTips struct {
+	Tips []SpecialWeatherTip `json:"swt"`
+}
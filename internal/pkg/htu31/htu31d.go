@@ -2,6 +2,8 @@ package htu31
 
 import (
     "encoding/binary"
+    "errors"
+    "fmt"
     "log"
     "time"
 
@@ -9,7 +11,8 @@ import (
 )
 
 const (
-    sensorAddr uint16 = 0x0040
+    // DefaultAddr is the address with the board's address jumper open.
+    DefaultAddr uint16 = 0x0040
 
     SoftReset     = 0x1E
     ReadSerial    = 0x0A
@@ -25,13 +28,26 @@ type TempHumidity struct {
     Humidity float64
 }
 
+var (
+    // ErrCRC is recorded when a reading fails its CRC check.
+    ErrCRC = errors.New("htu31: CRC mismatch")
+    // ErrNotHTU31 is returned by Probe when something else answers.
+    ErrNotHTU31 = errors.New("htu31: not an HTU31")
+)
+
 type Device struct {
     dev *i2c.Dev
+    err error
 }
 
 func New(i2cBus i2c.Bus) *Device {
+    return NewAt(i2cBus, DefaultAddr)
+}
+
+// NewAt returns the sensor at addr, 0x40 or 0x41.
+func NewAt(i2cBus i2c.Bus, addr uint16) *Device {
     d := new(Device)
-    d.dev = &i2c.Dev{Addr: sensorAddr, Bus: i2cBus}
+    d.dev = &i2c.Dev{Addr: addr, Bus: i2cBus}
     return d
 }
 
@@ -40,6 +56,7 @@ func (d *Device) SoftReset() {
     err := d.dev.Tx(outBuffer, nil)
     if err != nil {
         log.Printf("Error writing reset to Temp sensor %d\n", err)
+        d.fail(err)
     }
 }
 
@@ -48,6 +65,7 @@ func (d *Device) HeaterOff() {
     err := d.dev.Tx(outBuffer, nil)
     if err != nil {
         log.Printf("Error writing HeaterOff to Temp sensor %d\n", err)
+        d.fail(err)
     }
 }
 
@@ -57,6 +75,7 @@ func (d *Device) ReadSerial() uint32 {
     err := d.dev.Tx(outBuffer, inBuffer)
     if err != nil {
         log.Printf("Error reading from Temp sensor %d\n", err)
+        d.fail(err)
     }
 
     serial := binary.BigEndian.Uint32(inBuffer)
@@ -68,6 +87,7 @@ func (d *Device) Conversion() {
     err := d.dev.Tx(outBuffer, nil)
     if err != nil {
         log.Printf("Error writing to Temp sensor %d\n", err)
+        d.fail(err)
     }
 }
 
@@ -78,17 +98,19 @@ func (d *Device) ReadTempHumid() (float64, float64) {
     err := d.dev.Tx(outBuffer, inBuffer)
     if err != nil {
         log.Printf("Error writing to Temp sensor %d\n", err)
+        d.fail(err)
     }
 
     temperatureRaw := binary.BigEndian.Uint16(inBuffer[0:2])
     temperatureCRC := inBuffer[2]
 
-    crcValue := crc(uint32(temperatureRaw))
+    crcValue := crc(uint32(temperatureRaw), 16)
 
     var temperature float64
 
     if crcValue != temperatureCRC {
         log.Printf("CRC incorrect for temperature, dev 0x%x != calc 0x%x", temperatureCRC, crcValue)
+        d.fail(ErrCRC)
     }
 
     //log.Printf("temperature Raw = 0x%x %d", temperatureRaw, temperatureRaw)
@@ -98,10 +120,11 @@ func (d *Device) ReadTempHumid() (float64, float64) {
     humidityRaw := binary.BigEndian.Uint16(inBuffer[3:5])
     humidityCRC := inBuffer[5]
 
-    crcValue = crc(uint32(humidityRaw))
+    crcValue = crc(uint32(humidityRaw), 16)
 
     if crcValue != humidityCRC {
         log.Printf("CRC incorrect for humidity, dev 0x%x != calc 0x%x", humidityCRC, crcValue)
+        d.fail(ErrCRC)
     }
 
     humidity := 100 * float64(humidityRaw) / (2<<15 - 1)
@@ -109,39 +132,49 @@ func (d *Device) ReadTempHumid() (float64, float64) {
     return temperature, humidity
 }
 
-func DoLoop(i2cBus i2c.Bus, channel chan TempHumidity, sleep time.Duration) {
-
-    d := New(i2cBus)
-
+// Init resets the sensor and turns the heater off, returning its serial
+// number.
+func (d *Device) Init() uint32 {
     d.SoftReset()
     time.Sleep(500 * time.Millisecond)
     d.HeaterOff()
     time.Sleep(1 * time.Second)
 
-    serial := d.ReadSerial()
-    log.Printf("serial %d\n", serial)
-
-    sleep = sleep - ConversionPause
-
-    for {
-        d.Conversion()
-
-        time.Sleep(ConversionPause)
+    return d.ReadSerial()
+}
 
-        temperature, humidity := d.ReadTempHumid()
+// Reset soft resets the sensor and turns the heater off again, returning
+// any error along the way.
+func (d *Device) Reset() error {
+    d.Err()
+    d.SoftReset()
+    time.Sleep(500 * time.Millisecond)
+    d.HeaterOff()
+    return d.Err()
+}
 
-        channel <- TempHumidity{temperature, humidity}
+// Err returns the first error since the last call to Err, and clears it.
+// The other methods log errors and carry on, so a caller checks Err to know
+// whether what they returned can be trusted.
+func (d *Device) Err() error {
+    err := d.err
+    d.err = nil
+    return err
+}
 
-        time.Sleep(sleep)
+func (d *Device) fail(err error) {
+    if d.err == nil {
+        d.err = err
     }
-
 }
 
-func crc(input uint32) uint8 {
+// crc returns the CRC-8 of the low bits of input, 16 for a reading and 24
+// for the serial number.
+func crc(input uint32, bits uint) uint8 {
 
-    var polynom uint32 = 0x988000
-    var msb uint32 = 0x800000
-    var mask uint32 = 0xFF8000
+    polynom := uint32(0x131) << (bits - 1)
+    msb := uint32(1) << (bits + 7)
+    mask := uint32(0x1FF) << (bits - 1)
 
     result := input << 8
 
@@ -158,3 +191,23 @@ func crc(input uint32) uint8 {
 
     return uint8(result)
 }
+
+// Probe checks for an HTU31 at addr by reading its serial number, without
+// resetting it or logging errors. The serial is three bytes and a CRC, so
+// another device answering the command is unlikely to pass.
+func Probe(i2cBus i2c.Bus, addr uint16) (uint32, error) {
+    inBuffer := make([]byte, 6)
+    err := i2cBus.Tx(addr, []byte{ReadSerial}, inBuffer)
+    if err != nil {
+        return 0, err
+    }
+
+    serial := binary.BigEndian.Uint32(inBuffer)
+    if serial == 0 || serial == 0xFFFFFFFF {
+        return serial, ErrNotHTU31
+    }
+    if crc(serial>>8, 24) != uint8(serial) {
+        return serial, fmt.Errorf("%w: serial 0x%08x %v", ErrNotHTU31, serial, ErrCRC)
+    }
+    return serial, nil
+}
@@ -0,0 +1,353 @@
+// Package i2cbus shares one I2C bus between the sensor drivers. Transactions
+// are run one at a time, highest priority first, and a lock file stops a
+// second process, such as render_test, from using the bus at the same time.
+package i2cbus
+
+import (
+    "container/heap"
+    "errors"
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+
+    "periph.io/x/conn/v3/i2c"
+    "periph.io/x/conn/v3/i2c/i2creg"
+    "periph.io/x/conn/v3/physic"
+)
+
+// Priority orders transactions waiting for the bus; higher goes first.
+type Priority int
+
+const (
+    Low    Priority = -1
+    Normal Priority = 0
+    High   Priority = 1
+)
+
+// DEFAULT_TIMEOUT is how long a transaction waits for the bus by default.
+// It only bounds the wait: a transfer that has started runs to the end, as
+// the kernel's I2C calls can't be cancelled.
+const DEFAULT_TIMEOUT = 1 * time.Second
+
+// ErrTimeout is returned by Tx when the bus stayed busy for the whole
+// timeout. The transaction isn't sent.
+var ErrTimeout = errors.New("i2cbus: timed out waiting for the bus")
+
+// Tx is one finished transaction, for metrics. Wait is how long it queued
+// for the bus and Took how long the transfer itself took.
+type Tx struct {
+    Device string
+    Addr   uint16
+    Wait   time.Duration
+    Took   time.Duration
+    Err    error
+}
+
+// Bus runs transactions on an i2c.Bus one at a time. It is an i2c.Bus
+// itself, at Normal priority, and Device gives each driver its own.
+type Bus struct {
+    // OnTx, if set, is called after every transaction, including those
+    // that time out.
+    OnTx func(tx Tx)
+
+    bus    i2c.Bus
+    closer i2c.BusCloser
+    lock   *lockFile
+
+    mu      sync.Mutex
+    busy    bool
+    waiting waiters
+    arrived uint64
+}
+
+// New shares bus, which must not be used other than through the Bus.
+func New(bus i2c.Bus) *Bus {
+    return &Bus{bus: bus}
+}
+
+// Open opens the periph bus called name, "" for the first, after claiming
+// its lock file. It fails if another process has the bus.
+func Open(name string) (*Bus, error) {
+    lock, err := claim(busName(name))
+    if err != nil {
+        return nil, err
+    }
+
+    bus, err := i2creg.Open(name)
+    if err != nil {
+        lock.release()
+        return nil, err
+    }
+
+    b := New(bus)
+    b.closer = bus
+    b.lock = lock
+    return b, nil
+}
+
+// busName returns the registered name of the bus i2creg.Open would open for
+// name, so "", "1", "I2C1" and "/dev/i2c-1" all claim the same lock file.
+// Unknown names are returned as they are, for i2creg.Open to reject.
+func busName(name string) string {
+    refs := i2creg.All()
+    if len(refs) == 0 {
+        return name
+    }
+
+    if name == "" {
+        // the lowest numbered bus, or the first by name if none have numbers
+        first := refs[0]
+        for _, ref := range refs {
+            if ref.Number >= 0 && (first.Number < 0 || ref.Number < first.Number) {
+                first = ref
+            }
+        }
+        return first.Name
+    }
+
+    for _, ref := range refs {
+        if ref.Name == name {
+            return ref.Name
+        }
+    }
+    for _, ref := range refs {
+        for _, alias := range ref.Aliases {
+            if alias == name {
+                return ref.Name
+            }
+        }
+    }
+    if number, err := strconv.Atoi(name); err == nil {
+        for _, ref := range refs {
+            if ref.Number == number {
+                return ref.Name
+            }
+        }
+    }
+    return name
+}
+
+// Close closes the bus, if it was opened by Open, and releases the lock.
+func (b *Bus) Close() error {
+    var err error
+    if b.closer != nil {
+        err = b.closer.Close()
+        b.closer = nil
+    }
+    if b.lock != nil {
+        b.lock.release()
+        b.lock = nil
+    }
+    return err
+}
+
+func (b *Bus) String() string {
+    return b.bus.String()
+}
+
+func (b *Bus) Tx(addr uint16, w, r []byte) error {
+    return b.tx("", Normal, DEFAULT_TIMEOUT, addr, w, r)
+}
+
+func (b *Bus) SetSpeed(f physic.Frequency) error {
+    return b.bus.SetSpeed(f)
+}
+
+// Device returns the bus as seen by one driver, whose transactions are
+// reported as name and wait at most timeout for the bus, or DEFAULT_TIMEOUT
+// if it is 0. The timeout doesn't cover the transfer itself.
+func (b *Bus) Device(name string, priority Priority, timeout time.Duration) *Device {
+    if timeout <= 0 {
+        timeout = DEFAULT_TIMEOUT
+    }
+    return &Device{bus: b, name: name, priority: priority, timeout: timeout}
+}
+
+// Device is an i2c.Bus for one driver.
+type Device struct {
+    bus      *Bus
+    name     string
+    priority Priority
+    timeout  time.Duration
+}
+
+func (d *Device) String() string {
+    return fmt.Sprintf("%s(%s)", d.bus, d.name)
+}
+
+func (d *Device) Tx(addr uint16, w, r []byte) error {
+    return d.bus.tx(d.name, d.priority, d.timeout, addr, w, r)
+}
+
+// SetSpeed changes the speed of the whole bus.
+func (d *Device) SetSpeed(f physic.Frequency) error {
+    return d.bus.SetSpeed(f)
+}
+
+// Exclusive runs f with the bus to itself, for a driver that needs several
+// transactions without any others in between, such as a mux selecting a
+// channel. The bus given to f must not be used once f returns.
+func (d *Device) Exclusive(f func(bus i2c.Bus) error) error {
+    start := time.Now()
+
+    err := d.bus.acquire(d.priority, d.timeout)
+    if err != nil {
+        d.bus.report(Tx{Device: d.name, Wait: time.Since(start), Err: err})
+        return err
+    }
+    defer d.bus.release()
+
+    return f(&held{device: d, wait: time.Since(start)})
+}
+
+// held is the bus while a Device has it to itself.
+type held struct {
+    device *Device
+    // how long the device waited for the bus, reported with the first
+    // transaction
+    wait time.Duration
+}
+
+func (h *held) String() string {
+    return h.device.String()
+}
+
+func (h *held) Tx(addr uint16, w, r []byte) error {
+    b := h.device.bus
+
+    start := time.Now()
+    err := b.bus.Tx(addr, w, r)
+    b.report(Tx{Device: h.device.name, Addr: addr, Wait: h.wait, Took: time.Since(start), Err: err})
+    h.wait = 0
+
+    return err
+}
+
+func (h *held) SetSpeed(f physic.Frequency) error {
+    return h.device.bus.bus.SetSpeed(f)
+}
+
+func (b *Bus) tx(name string, priority Priority, timeout time.Duration, addr uint16, w, r []byte) error {
+    start := time.Now()
+
+    err := b.acquire(priority, timeout)
+    wait := time.Since(start)
+
+    var took time.Duration
+    if err == nil {
+        txStart := time.Now()
+        err = b.bus.Tx(addr, w, r)
+        took = time.Since(txStart)
+        b.release()
+    }
+
+    b.report(Tx{Device: name, Addr: addr, Wait: wait, Took: took, Err: err})
+    return err
+}
+
+func (b *Bus) report(tx Tx) {
+    if b.OnTx != nil {
+        b.OnTx(tx)
+    }
+}
+
+// acquire waits for the bus, handing it to waiters in priority order.
+func (b *Bus) acquire(priority Priority, timeout time.Duration) error {
+    b.mu.Lock()
+
+    if !b.busy {
+        b.busy = true
+        b.mu.Unlock()
+        return nil
+    }
+
+    b.arrived++
+    w := &waiter{priority: priority, arrived: b.arrived, ready: make(chan struct{})}
+    heap.Push(&b.waiting, w)
+    b.mu.Unlock()
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    select {
+    case <-w.ready:
+        return nil
+    case <-timer.C:
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    // handed the bus just as the timer fired
+    if w.index < 0 {
+        <-w.ready
+        b.releaseLocked()
+        return ErrTimeout
+    }
+
+    heap.Remove(&b.waiting, w.index)
+    return ErrTimeout
+}
+
+func (b *Bus) release() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.releaseLocked()
+}
+
+func (b *Bus) releaseLocked() {
+    if b.waiting.Len() == 0 {
+        b.busy = false
+        return
+    }
+
+    w := heap.Pop(&b.waiting).(*waiter)
+    close(w.ready)
+}
+
+type waiter struct {
+    priority Priority
+    arrived  uint64
+    ready    chan struct{}
+    // index in the heap, or -1 once handed the bus
+    index int
+}
+
+// waiters is a heap of the transactions waiting, highest priority and then
+// first to arrive at the top.
+type waiters []*waiter
+
+func (q waiters) Len() int {
+    return len(q)
+}
+
+func (q waiters) Less(i, j int) bool {
+    if q[i].priority != q[j].priority {
+        return q[i].priority > q[j].priority
+    }
+    return q[i].arrived < q[j].arrived
+}
+
+func (q waiters) Swap(i, j int) {
+    q[i], q[j] = q[j], q[i]
+    q[i].index = i
+    q[j].index = j
+}
+
+func (q *waiters) Push(x any) {
+    w := x.(*waiter)
+    w.index = len(*q)
+    *q = append(*q, w)
+}
+
+func (q *waiters) Pop() any {
+    old := *q
+    n := len(old)
+    w := old[n-1]
+    old[n-1] = nil
+    w.index = -1
+    *q = old[:n-1]
+    return w
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package i2cbus
+
+import "errors"
+
+// ErrBusInUse is returned by Open when another process has the bus. Other
+// processes aren't detected on this platform.
+var ErrBusInUse = errors.New("i2cbus: bus in use by another process")
+
+type lockFile struct{}
+
+func claim(name string) (*lockFile, error) {
+    return &lockFile{}, nil
+}
+
+func (l *lockFile) release() {}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package i2cbus
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+)
+
+// LockDir is where the lock files are kept.
+var LockDir = "/run/lock"
+
+// ErrBusInUse is returned by Open when another process has the bus.
+var ErrBusInUse = errors.New("i2cbus: bus in use by another process")
+
+type lockFile struct {
+    file *os.File
+}
+
+// claim takes an exclusive lock on the file for the named bus, which is
+// released by the kernel if the process dies.
+func claim(name string) (*lockFile, error) {
+    dir := LockDir
+    if _, err := os.Stat(dir); err != nil {
+        dir = os.TempDir()
+    }
+
+    if name == "" {
+        name = "default"
+    }
+    base := "airmon-i2c-" + strings.NewReplacer("/", "_", " ", "_").Replace(strings.TrimPrefix(name, "/dev/")) + ".lock"
+    path := filepath.Join(dir, base)
+
+    file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+
+    err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+    if err != nil {
+        owner, _ := os.ReadFile(path)
+        file.Close()
+        if errors.Is(err, syscall.EWOULDBLOCK) {
+            return nil, fmt.Errorf("%w: %s held by pid %s", ErrBusInUse, path, strings.TrimSpace(string(owner)))
+        }
+        return nil, fmt.Errorf("i2cbus: locking %s: %w", path, err)
+    }
+
+    file.Truncate(0)
+    fmt.Fprintf(file, "%d\n", os.Getpid())
+
+    return &lockFile{file: file}, nil
+}
+
+func (l *lockFile) release() {
+    syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+    l.file.Close()
+}
@@ -0,0 +1,105 @@
+package i2cbus
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "periph.io/x/conn/v3/gpio"
+    "periph.io/x/conn/v3/i2c"
+    "periph.io/x/conn/v3/pin"
+)
+
+// ErrNoRecovery is returned by Recover when the bus doesn't expose its pins.
+var ErrNoRecovery = errors.New("i2cbus: bus pins not available for recovery")
+
+// ErrStuck is returned by Recover when a device still holds SDA low.
+var ErrStuck = errors.New("i2cbus: SDA still held low")
+
+// recovering goes ahead of every transaction.
+const recovering Priority = High + 1
+
+// halfClock is half an SCL period, for 100kHz.
+const halfClock = 5 * time.Microsecond
+
+// Recover frees a bus left stuck by a device part way through a byte, such
+// as after a brown-out, by clocking SCL until the device lets go of SDA and
+// then sending a STOP. It waits for the transaction in progress and holds
+// off the rest until it is done.
+func (b *Bus) Recover() error {
+    pins, ok := b.bus.(i2c.Pins)
+    if !ok {
+        return ErrNoRecovery
+    }
+
+    scl, sda := pins.SCL(), pins.SDA()
+    if scl == nil || sda == nil || scl == gpio.INVALID || sda == gpio.INVALID {
+        return ErrNoRecovery
+    }
+
+    err := b.acquire(recovering, time.Minute)
+    if err != nil {
+        return err
+    }
+    defer b.release()
+
+    err = clockOut(scl, sda)
+
+    // hand the pins back to the I2C controller
+    for _, p := range []struct {
+        pin gpio.PinIO
+        fn  pin.Func
+    }{{scl, i2c.SCL}, {sda, i2c.SDA}} {
+        if pf, ok := p.pin.(pin.PinFunc); ok {
+            if fnErr := pf.SetFunc(p.fn); fnErr != nil && err == nil {
+                err = fmt.Errorf("i2cbus: restoring %s: %w", p.pin, fnErr)
+            }
+        }
+    }
+
+    return err
+}
+
+// clockOut drives the pins as open drain: low is an output, high is an input
+// left to the pull-up.
+func clockOut(scl gpio.PinIO, sda gpio.PinIO) error {
+    release := func(p gpio.PinIO) error { return p.In(gpio.PullUp, gpio.NoEdge) }
+    low := func(p gpio.PinIO) error { return p.Out(gpio.Low) }
+
+    err := release(sda)
+    if err != nil {
+        return err
+    }
+    err = release(scl)
+    if err != nil {
+        return err
+    }
+    time.Sleep(halfClock)
+
+    // a device mid-byte lets go within nine clocks
+    for i := 0; i < 9 && sda.Read() == gpio.Low; i++ {
+        if err = low(scl); err != nil {
+            return err
+        }
+        time.Sleep(halfClock)
+        if err = release(scl); err != nil {
+            return err
+        }
+        time.Sleep(halfClock)
+    }
+
+    // STOP: SDA rises while SCL is high
+    if err = low(sda); err != nil {
+        return err
+    }
+    time.Sleep(halfClock)
+    if err = release(sda); err != nil {
+        return err
+    }
+    time.Sleep(halfClock)
+
+    if sda.Read() == gpio.Low {
+        return ErrStuck
+    }
+    return nil
+}
@@ -0,0 +1,199 @@
+// Package metar parses METAR aviation weather reports, for units away from
+// Hong Kong, and provides them as outdoor weather.
+package metar
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// METAR is the parts of a report airmon uses. Temperature, DewPoint and
+// Pressure are only meaningful if the matching Has field is set.
+type METAR struct {
+    Raw         string
+    Station     string
+    Time        time.Time
+    Temperature float64
+    DewPoint    float64
+    HasTemp     bool
+    Pressure    float64 // hPa
+    HasPressure bool
+    // Weather is the present weather groups, such as "-SHRA" or "TS".
+    Weather []string
+    // Cover is the most cloud reported: "CLR", "FEW", "SCT", "BKN" or "OVC".
+    Cover string
+}
+
+var ErrNotMETAR = errors.New("metar: not a METAR report")
+
+var (
+    stationPattern     = regexp.MustCompile(`^[A-Z][A-Z0-9]{3}$`)
+    timePattern        = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})Z$`)
+    temperaturePattern = regexp.MustCompile(`^(M?\d{2})/(M?\d{2})?$`)
+    qnhPattern         = regexp.MustCompile(`^Q(\d{4})$`)
+    altimeterPattern   = regexp.MustCompile(`^A(\d{4})$`)
+    cloudPattern       = regexp.MustCompile(`^(FEW|SCT|BKN|OVC|VV)\d{3}`)
+    weatherPattern     = regexp.MustCompile(`^[-+]?(VC)?(MI|PR|BC|DR|BL|SH|TS|FZ)?(DZ|RA|SN|SG|IC|PL|GR|GS|UP|BR|FG|FU|VA|DU|SA|HZ|PY|PO|SQ|FC|SS|DS)+$|^TS$`)
+)
+
+var coverRank = map[string]int{"CLR": 0, "FEW": 1, "SCT": 2, "BKN": 3, "OVC": 4, "VV": 4}
+
+// Parse parses a METAR such as
+//
+//	VHHH 011230Z 20008KT 9999 -SHRA FEW012 SCT025 28/25 Q1006 NOSIG
+//
+// The report only gives the day of the month, so now is used to work out
+// the month it is from.
+func Parse(raw string, now time.Time) (METAR, error) {
+    fields := strings.Fields(raw)
+
+    for len(fields) > 0 && (fields[0] == "METAR" || fields[0] == "SPECI") {
+        fields = fields[1:]
+    }
+
+    if len(fields) < 2 || !stationPattern.MatchString(fields[0]) {
+        return METAR{}, ErrNotMETAR
+    }
+
+    m := METAR{Raw: strings.TrimSpace(raw), Station: fields[0], Cover: "CLR"}
+
+    observed := timePattern.FindStringSubmatch(fields[1])
+    if observed == nil {
+        return METAR{}, fmt.Errorf("metar: bad time %q", fields[1])
+    }
+    t, err := reportTime(observed, now)
+    if err != nil {
+        return METAR{}, err
+    }
+    m.Time = t
+
+    for _, field := range fields[2:] {
+        // trends and remarks describe later or other conditions
+        if field == "RMK" || field == "NOSIG" || field == "BECMG" || field == "TEMPO" {
+            break
+        }
+
+        switch {
+        case field == "CAVOK" || field == "NSC" || field == "SKC" || field == "NCD":
+            m.Cover = "CLR"
+
+        case cloudPattern.MatchString(field):
+            cover := cloudPattern.FindStringSubmatch(field)[1]
+            if coverRank[cover] > coverRank[m.Cover] {
+                m.Cover = cover
+            }
+
+        case temperaturePattern.MatchString(field):
+            parts := temperaturePattern.FindStringSubmatch(field)
+            m.Temperature = parseTemperature(parts[1])
+            m.DewPoint = math.NaN()
+            if parts[2] != "" {
+                m.DewPoint = parseTemperature(parts[2])
+            }
+            m.HasTemp = true
+
+        case qnhPattern.MatchString(field):
+            hPa, _ := strconv.Atoi(qnhPattern.FindStringSubmatch(field)[1])
+            m.Pressure = float64(hPa)
+            m.HasPressure = true
+
+        case altimeterPattern.MatchString(field):
+            inHg, _ := strconv.Atoi(altimeterPattern.FindStringSubmatch(field)[1])
+            m.Pressure = math.Round(float64(inHg)/100*33.8639*10) / 10
+            m.HasPressure = true
+
+        case weatherPattern.MatchString(field):
+            m.Weather = append(m.Weather, field)
+        }
+    }
+
+    return m, nil
+}
+
+func parseTemperature(s string) float64 {
+    negative := strings.HasPrefix(s, "M")
+    value, _ := strconv.Atoi(strings.TrimPrefix(s, "M"))
+    if negative {
+        return -float64(value)
+    }
+    return float64(value)
+}
+
+// reportTime finds the latest time on or before now with the report's day,
+// hour and minute. Months are stepped back from now's, as a day such as the
+// 31st isn't in every month, and a report that fits none of the past year
+// is an error.
+func reportTime(observed []string, now time.Time) (time.Time, error) {
+    day, _ := strconv.Atoi(observed[1])
+    hour, _ := strconv.Atoi(observed[2])
+    minute, _ := strconv.Atoi(observed[3])
+
+    if day < 1 || day > 31 || hour > 23 || minute > 59 {
+        return time.Time{}, fmt.Errorf("metar: bad time %q", observed[0])
+    }
+
+    now = now.UTC()
+    for back := 0; back < 12; back++ {
+        t := time.Date(now.Year(), now.Month()-time.Month(back), day, hour, minute, 0, 0, time.UTC)
+
+        // allow for the clocks being a little out
+        if t.Day() == day && !t.After(now.Add(time.Hour)) {
+            return t, nil
+        }
+    }
+
+    return time.Time{}, fmt.Errorf("metar: no month has time %q", observed[0])
+}
+
+// Humidity is the relative humidity worked out from the dew point, or false
+// if the report doesn't have one.
+func (m METAR) Humidity() (float64, bool) {
+    if !m.HasTemp || math.IsNaN(m.DewPoint) {
+        return 0, false
+    }
+
+    // Magnus formula
+    const b, c = 17.625, 243.04
+    rh := 100 * math.Exp(b*m.DewPoint/(c+m.DewPoint)) / math.Exp(b*m.Temperature/(c+m.Temperature))
+    return math.Min(100, math.Round(rh)), true
+}
+
+// Icon maps the present weather and cloud cover onto an HKO weather icon
+// number, as used by the display.
+func (m METAR) Icon() int {
+    for _, w := range m.Weather {
+        switch {
+        case strings.Contains(w, "TS"):
+            return 65
+        case strings.HasPrefix(w, "+") && (strings.Contains(w, "RA") || strings.Contains(w, "SH")):
+            return 64
+        case strings.HasPrefix(w, "-") && (strings.Contains(w, "RA") || strings.Contains(w, "DZ")):
+            return 62
+        case strings.Contains(w, "RA") || strings.Contains(w, "DZ") || strings.Contains(w, "SH"):
+            return 63
+        case strings.Contains(w, "SN") || strings.Contains(w, "PL") || strings.Contains(w, "GR"):
+            return 93
+        case strings.Contains(w, "FG"):
+            return 83
+        case strings.Contains(w, "BR"):
+            return 84
+        case strings.Contains(w, "HZ") || strings.Contains(w, "FU") || strings.Contains(w, "DU"):
+            return 85
+        }
+    }
+
+    switch m.Cover {
+    case "FEW", "SCT":
+        return 51
+    case "BKN":
+        return 60
+    case "OVC", "VV":
+        return 61
+    }
+    return 50
+}
@@ -0,0 +1,136 @@
+package metar
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/weather"
+)
+
+// DEFAULT_URL returns the latest raw METAR for the station given in ids.
+const DEFAULT_URL = "https://aviationweather.gov/api/data/metar?format=raw"
+
+// Provider fetches the latest METAR for Station, such as "VHHH", from URL.
+// The station is added to URL as the ids parameter.
+type Provider struct {
+    Station string
+    URL     string
+    HTTP    *http.Client
+
+    mu   sync.Mutex
+    last weather.Report
+}
+
+func NewProvider(station string) *Provider {
+    return &Provider{
+        Station: strings.ToUpper(station),
+        URL:     DEFAULT_URL,
+        HTTP:    &http.Client{Timeout: 15 * time.Second},
+    }
+}
+
+func (p *Provider) Name() string {
+    return "metar"
+}
+
+// Fetch returns the latest report, or the last good one marked Stale if that
+// fails.
+func (p *Provider) Fetch(ctx context.Context) (weather.Report, error) {
+    report, err := p.fetch(ctx)
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if err != nil {
+        last := p.last
+        if !last.Fetched.IsZero() {
+            last.Stale = true
+        }
+        return last, err
+    }
+
+    p.last = report
+    return report, nil
+}
+
+func (p *Provider) fetch(ctx context.Context) (weather.Report, error) {
+    requestURL, err := url.Parse(p.URL)
+    if err != nil {
+        return weather.Report{}, err
+    }
+    query := requestURL.Query()
+    query.Set("ids", p.Station)
+    requestURL.RawQuery = query.Encode()
+
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+    if err != nil {
+        return weather.Report{}, err
+    }
+
+    httpClient := p.HTTP
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+
+    response, err := httpClient.Do(request)
+    if err != nil {
+        return weather.Report{}, err
+    }
+    defer response.Body.Close()
+
+    if response.StatusCode != http.StatusOK {
+        return weather.Report{}, fmt.Errorf("metar: %s returned %s", requestURL, response.Status)
+    }
+
+    body, err := io.ReadAll(response.Body)
+    if err != nil {
+        return weather.Report{}, err
+    }
+
+    now := time.Now()
+
+    // the first line for our station is the latest
+    scanner := bufio.NewScanner(bytes.NewReader(body))
+    for scanner.Scan() {
+        m, err := Parse(scanner.Text(), now)
+        if err != nil || m.Station != p.Station {
+            continue
+        }
+        return m.Report(now), nil
+    }
+
+    return weather.Report{}, fmt.Errorf("metar: no report for %s", p.Station)
+}
+
+// Report converts the METAR to a weather.Report, with readings placed at the
+// lower case station code.
+func (m METAR) Report(fetched time.Time) weather.Report {
+    place := strings.ToLower(m.Station)
+
+    report := weather.Report{
+        Source:   "metar",
+        Observed: m.Time,
+        Fetched:  fetched,
+        Icon:     m.Icon(),
+    }
+
+    if m.HasTemp {
+        report.Temperature = weather.Reading{Place: place, Value: m.Temperature}
+    }
+    if humidity, ok := m.Humidity(); ok {
+        report.Humidity = weather.Reading{Place: place, Value: humidity}
+    }
+    if m.HasPressure {
+        report.Pressure = weather.Reading{Place: place, Value: m.Pressure}
+    }
+
+    return report
+}
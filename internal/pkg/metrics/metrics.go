@@ -8,51 +8,159 @@ import (
     "net/http"
 )
 
+// sensorLabels label each reading with the sensor it came from, by the name
+// in the config, and where that sensor is.
+var sensorLabels = []string{"sensor", "location"}
+
 var (
-    TemperatureMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    TemperatureMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "temperature",
         Help: "Current temperature",
-    })
+    }, sensorLabels)
 
-    HumidityMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    HumidityMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "humidity",
         Help: "Current humidity",
-    })
+    }, sensorLabels)
 
-    PM10StdMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    PM10StdMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm10std",
         Help: "PM1.0 Standard",
-    })
+    }, sensorLabels)
 
-    PM25StdMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    PM25StdMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm25std",
         Help: "PM2.5 Standard",
-    })
+    }, sensorLabels)
 
-    PM100StdMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    PM100StdMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm100std",
         Help: "PM10 Standard",
-    })
+    }, sensorLabels)
 
-    PM10Concentration = promauto.NewGauge(prometheus.GaugeOpts{
+    PM10Concentration = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm10concentration",
         Help: "PM1.0 Concentration",
-    })
+    }, sensorLabels)
 
-    PM25Concentration = promauto.NewGauge(prometheus.GaugeOpts{
+    PM25Concentration = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm25concentration",
         Help: "PM2.5 Concentration",
-    })
+    }, sensorLabels)
 
-    PM100Concentration = promauto.NewGauge(prometheus.GaugeOpts{
+    PM100Concentration = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pm100concentration",
         Help: "PM10 Concentration",
-    })
+    }, sensorLabels)
 
-    PressureMetric = promauto.NewGauge(prometheus.GaugeOpts{
+    PressureMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
         Name: "pressure",
         Help: "Atmospheric pressure",
+    }, sensorLabels)
+
+    DisplayBusyTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "display_busy_timeouts_total",
+        Help: "E-paper refreshes aborted because the panel stayed busy",
+    })
+
+    DisplayDeferredRefreshes = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "display_deferred_refreshes_total",
+        Help: "E-paper refreshes deferred because of the ambient temperature",
     })
+
+    DisplayPanelTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "display_panel_temperature",
+        Help: "Temperature the e-paper controller is using, from source internal (its own sensor) or ambient (forced from the room sensor)",
+    }, []string{"source"})
+
+    DisplayStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "display_status",
+        Help: "Flags of the e-paper controller's status register after the last refresh, 1 if set",
+    }, []string{"flag"})
+
+    OutdoorTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_temperature",
+        Help: "Outdoor temperature from the weather source",
+    }, []string{"source", "station"})
+
+    OutdoorHumidity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_humidity",
+        Help: "Outdoor humidity from the weather source",
+    }, []string{"source", "station"})
+
+    OutdoorPressure = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_pressure",
+        Help: "Outdoor atmospheric pressure from the weather source",
+    }, []string{"source", "station"})
+
+    OutdoorRainfall = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_rainfall_mm",
+        Help: "Maximum rainfall in the district over the past hour",
+    }, []string{"source", "district"})
+
+    OutdoorUVIndex = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_uv_index",
+        Help: "UV index over the past hour, only reported in daylight",
+    }, []string{"source", "station"})
+
+    WeatherWarning = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "weather_warning",
+        Help: "1 for each weather warning or signal in force, such as warning=WTCSGNL code=TC8NE",
+    }, []string{"source", "warning", "code"})
+
+    OutdoorUpdateTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "outdoor_update_timestamp_seconds",
+        Help: "When the weather source last updated its observations",
+    }, []string{"source"})
+
+    SensorReadDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "sensor_read_delay_seconds",
+        Help:    "How long after its scheduled time a sensor read started",
+        Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+    }, []string{"sensor"})
+
+    SensorReadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "sensor_read_duration_seconds",
+        Help:    "Time spent talking to a sensor for one reading, excluding conversion time",
+        Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+    }, []string{"sensor"})
+
+    SensorOverruns = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "sensor_schedule_overruns_total",
+        Help: "Scheduled sensor reads skipped because the previous read overran",
+    }, []string{"sensor"})
+
+    SensorHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "sensor_healthy",
+        Help: "1 while a sensor is reading, 0 once it has failed enough reads in a row to be reset",
+    }, []string{"sensor"})
+
+    SensorConsecutiveFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "sensor_consecutive_failures",
+        Help: "Failed reads of a sensor since its last good one",
+    }, []string{"sensor"})
+
+    SensorRecoveries = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "sensor_recoveries_total",
+        Help: "Attempts to recover a failing sensor, by action: reset or bus, and result: ok or failed",
+    }, []string{"sensor", "action", "result"})
+
+    I2CWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "i2c_transaction_wait_seconds",
+        Help:    "Time an I2C transaction waited for the bus",
+        Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+    }, []string{"device"})
+
+    I2CDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "i2c_transaction_duration_seconds",
+        Help:    "Time an I2C transaction took on the bus",
+        Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+    }, []string{"device"})
+
+    I2CErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "i2c_transaction_errors_total",
+        Help: "Failed I2C transactions, by reason: timeout waiting for the bus or error",
+    }, []string{"device", "reason"})
 )
 
 func StartServer(addr string) {
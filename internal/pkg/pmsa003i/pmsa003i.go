@@ -2,8 +2,8 @@ package pmsa003i
 
 import (
     "encoding/binary"
+    "errors"
     "log"
-    "time"
 
     "periph.io/x/conn/v3/i2c"
 )
@@ -30,13 +30,28 @@ const (
     PmSensorAddr uint16 = 0x0012
 )
 
+var (
+    // ErrChecksum is recorded when a frame fails its checksum.
+    ErrChecksum = errors.New("pmsa003i: checksum mismatch")
+    // ErrFrame is recorded when a frame doesn't start with the start bytes,
+    // and returned by Probe when something else answers.
+    ErrFrame = errors.New("pmsa003i: bad frame start")
+)
+
 type PMSA003I struct {
     dev *i2c.Dev
+    err error
 }
 
 func New(i2cBus i2c.Bus) *PMSA003I {
+    return NewAt(i2cBus, PmSensorAddr)
+}
+
+// NewAt returns the sensor at addr. The PMSA003I's address is fixed, so this
+// is only needed behind a translator.
+func NewAt(i2cBus i2c.Bus, addr uint16) *PMSA003I {
     d := new(PMSA003I)
-    d.dev = &i2c.Dev{Addr: PmSensorAddr, Bus: i2cBus}
+    d.dev = &i2c.Dev{Addr: addr, Bus: i2cBus}
     return d
 }
 
@@ -45,6 +60,11 @@ func (d *PMSA003I) Read() PMSensorValue {
     err := d.dev.Tx(nil, inBuffer)
     if err != nil {
         log.Printf("Error reading from Temp sensor %d\n", err)
+        d.fail(err)
+    }
+
+    if inBuffer[0] != 0x42 || inBuffer[1] != 0x4d {
+        d.fail(ErrFrame)
     }
 
     var checkSum uint16 = 0
@@ -78,19 +98,36 @@ func (d *PMSA003I) Read() PMSensorValue {
 
     if devCheckSum != checkSum {
         log.Printf("Checksum mismatch dev 0x%x != calc 0x%x", devCheckSum, checkSum)
+        d.fail(ErrChecksum)
     }
 
     return values
 }
 
-func DoLoop(i2cBus i2c.Bus, channel chan PMSensorValue, sleep time.Duration) {
+// Err returns the first error since the last call to Err, and clears it.
+func (d *PMSA003I) Err() error {
+    err := d.err
+    d.err = nil
+    return err
+}
 
-    d := New(i2cBus)
+func (d *PMSA003I) fail(err error) {
+    if d.err == nil {
+        d.err = err
+    }
+}
 
-    for {
-        values := d.Read()
-        channel <- values
-        time.Sleep(sleep)
+// Probe checks for a PMSA003I at addr by reading a frame and checking its
+// start bytes, returning the frame's version.
+func Probe(i2cBus i2c.Bus, addr uint16) (byte, error) {
+    inBuffer := make([]byte, 32)
+    err := i2cBus.Tx(addr, nil, inBuffer)
+    if err != nil {
+        return 0, err
     }
 
+    if inBuffer[0] != 0x42 || inBuffer[1] != 0x4d {
+        return 0, ErrFrame
+    }
+    return inBuffer[28], nil
 }
@@ -0,0 +1,172 @@
+// Package schedule runs each sensor at its own interval, with readings taken
+// on wall-clock boundaries so samples from different sensors and restarts
+// line up.
+package schedule
+
+import (
+    "sync"
+    "time"
+)
+
+// Job reads a sensor every Interval, on multiples of Interval since the
+// Unix epoch. A sensor that needs time to convert sets Lead and Prepare:
+// Prepare starts the conversion Lead before the boundary, so Read gets the
+// result on the boundary rather than Lead after it.
+type Job struct {
+    Name     string
+    Interval time.Duration
+    Lead     time.Duration
+    Prepare  func()
+    Read     func()
+}
+
+// Run is how one run of a job went. Late is how long after the boundary
+// Read started, Took is how long Prepare and Read took between them, and
+// Missed is how many boundaries were skipped because the run overran.
+type Run struct {
+    Job    string
+    At     time.Time
+    Late   time.Duration
+    Took   time.Duration
+    Missed int
+}
+
+// Scheduler runs jobs, each in its own goroutine.
+type Scheduler struct {
+    // OnRun, if set, is called after every run.
+    OnRun func(run Run)
+
+    mu   sync.Mutex
+    jobs map[string]*running
+}
+
+type running struct {
+    stop chan struct{}
+    done chan struct{}
+}
+
+func New() *Scheduler {
+    return &Scheduler{jobs: make(map[string]*running)}
+}
+
+// Add starts job, replacing any job with the same name once its run in
+// progress has finished.
+func (s *Scheduler) Add(job Job) {
+    s.Remove(job.Name)
+
+    r := &running{stop: make(chan struct{}), done: make(chan struct{})}
+
+    s.mu.Lock()
+    s.jobs[job.Name] = r
+    s.mu.Unlock()
+
+    go s.run(job, r)
+}
+
+// Remove stops the named job, waiting for a run in progress to finish.
+func (s *Scheduler) Remove(name string) {
+    s.mu.Lock()
+    r, ok := s.jobs[name]
+    delete(s.jobs, name)
+    s.mu.Unlock()
+
+    if ok {
+        close(r.stop)
+        <-r.done
+    }
+}
+
+// Stop stops every job.
+func (s *Scheduler) Stop() {
+    s.mu.Lock()
+    names := make([]string, 0, len(s.jobs))
+    for name := range s.jobs {
+        names = append(names, name)
+    }
+    s.mu.Unlock()
+
+    for _, name := range names {
+        s.Remove(name)
+    }
+}
+
+// Next is the first boundary of interval after t.
+func Next(t time.Time, interval time.Duration) time.Time {
+    return t.Truncate(interval).Add(interval)
+}
+
+func (s *Scheduler) run(job Job, r *running) {
+    defer close(r.done)
+
+    if job.Interval <= 0 {
+        return
+    }
+
+    // leave time for the first conversion
+    next := Next(time.Now().Add(job.Lead), job.Interval)
+
+    for {
+        var took time.Duration
+
+        if job.Prepare != nil {
+            if !sleep(time.Until(next.Add(-job.Lead)), r.stop) {
+                return
+            }
+
+            start := time.Now()
+            job.Prepare()
+            took = time.Since(start)
+        }
+
+        if !sleep(time.Until(next), r.stop) {
+            return
+        }
+
+        start := time.Now()
+        job.Read()
+        end := time.Now()
+        took += end.Sub(start)
+
+        // skip the boundaries there is no longer time for
+        following := next.Add(job.Interval)
+        missed := 0
+        for following.Add(-job.Lead).Before(end) {
+            following = following.Add(job.Interval)
+            missed++
+        }
+
+        if s.OnRun != nil {
+            s.OnRun(Run{
+                Job:    job.Name,
+                At:     next,
+                Late:   start.Sub(next),
+                Took:   took,
+                Missed: missed,
+            })
+        }
+
+        next = following
+    }
+}
+
+// sleep waits for d, or returns false if stop is closed first.
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+    if d <= 0 {
+        select {
+        case <-stop:
+            return false
+        default:
+            return true
+        }
+    }
+
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return true
+    case <-stop:
+        return false
+    }
+}
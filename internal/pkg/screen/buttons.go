@@ -0,0 +1,75 @@
+package screen
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "periph.io/x/conn/v3/gpio"
+    "periph.io/x/conn/v3/gpio/gpioreg"
+)
+
+// Buttons on the Inky Impression, top to bottom.
+var DefaultButtonPins = []string{"GPIO5", "GPIO6", "GPIO16", "GPIO24"}
+
+// debounce is how long after a press further edges on the same button are
+// ignored.
+const debounce = 250 * time.Millisecond
+
+// WatchButtons waits for presses on the given pins, which pull to ground when
+// pressed, and sends the index of the button pressed on presses. It returns
+// once every pin is being watched.
+func WatchButtons(pins []string, presses chan<- int) error {
+
+    buttons := make([]gpio.PinIO, len(pins))
+
+    for i, name := range pins {
+        pin := gpioreg.ByName(name)
+        if pin == nil {
+            return fmt.Errorf("unable to find %s", name)
+        }
+
+        err := pin.In(gpio.PullUp, gpio.FallingEdge)
+        if err != nil {
+            return fmt.Errorf("%s: %w", name, err)
+        }
+
+        buttons[i] = pin
+    }
+
+    for i, pin := range buttons {
+        go watchButton(i, pin, presses)
+    }
+
+    return nil
+}
+
+func watchButton(index int, pin gpio.PinIO, presses chan<- int) {
+
+    var last time.Time
+
+    for {
+        if !pin.WaitForEdge(-1) {
+            continue
+        }
+
+        now := time.Now()
+        if now.Sub(last) < debounce {
+            continue
+        }
+
+        // ignore glitches that don't leave the button held down
+        time.Sleep(20 * time.Millisecond)
+        if pin.Read() != gpio.Low {
+            continue
+        }
+
+        last = now
+
+        select {
+        case presses <- index:
+        default:
+            log.Printf("Dropping press of button %d, screen is busy", index)
+        }
+    }
+}
@@ -0,0 +1,280 @@
+// Package screen drives the e-paper display as a set of pages, switched with
+// the buttons on the side of the panel or on a timer.
+package screen
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "image/draw"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg"
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+    "github.com/tony-tsang/airmon/internal/pkg/widget"
+)
+
+// Data is everything the pages can show. The Manager asks for a fresh copy
+// before every refresh.
+type Data struct {
+    Weather    pkg.WeatherData
+    History    *history.Store
+    Sensors    []SensorStatus
+    Ambient    float64
+    HasAmbient bool
+    Now        time.Time
+}
+
+// SensorStatus is shown on the diagnostics page.
+type SensorStatus struct {
+    Name     string
+    LastSeen time.Time
+    Detail   string
+}
+
+type Page interface {
+    Name() string
+    Draw(dst draw.Image, data *Data) error
+}
+
+// Manager shows one page at a time. Pages change when a button is pressed
+// or every Rotate, and the current page is redrawn every Redraw to pick up
+// new readings. Refreshes are never closer together than MinInterval, since
+// the panel takes around 30 seconds to refresh; presses in the meantime are
+// coalesced into one refresh. A refresh the panel puts off, because it is
+// busy or too cold or hot, is tried again after MinInterval.
+type Manager struct {
+    Display     *uc8159.Display
+    Fonts       *widget.Fonts
+    Pages       []Page
+    Rotate      time.Duration
+    Redraw      time.Duration
+    MinInterval time.Duration
+    Image       uc8159.ImageOptions
+
+    // OnRefresh, if set, is called with the result of every refresh.
+    OnRefresh func(page Page, err error)
+
+    current     int
+    lastRefresh time.Time
+
+    changes  chan layout
+    stop     chan struct{}
+    done     chan struct{}
+    stopOnce sync.Once
+}
+
+// layout is a change of pages made while running.
+type layout struct {
+    pages  []Page
+    rotate time.Duration
+}
+
+func NewManager(display *uc8159.Display, fonts *widget.Fonts, pages []Page) *Manager {
+    return &Manager{
+        Display:     display,
+        Fonts:       fonts,
+        Pages:       pages,
+        Redraw:      5 * time.Minute,
+        MinInterval: 1 * time.Minute,
+        Image:       uc8159.DefaultImageOptions,
+        changes:     make(chan layout, 1),
+        stop:        make(chan struct{}),
+        done:        make(chan struct{}),
+    }
+}
+
+// DefaultPages returns the overview, air quality, forecast and diagnostics
+// pages, in the order of the buttons.
+func DefaultPages(fonts *widget.Fonts) []Page {
+    return []Page{
+        &OverviewPage{Fonts: fonts},
+        &AirQualityPage{Fonts: fonts},
+        &ForecastPage{Fonts: fonts},
+        &DiagnosticsPage{Fonts: fonts},
+    }
+}
+
+// PageNames are the names PagesByName accepts.
+var PageNames = []string{"overview", "air", "trends", "forecast", "warnings", "sensors"}
+
+// PagesByName returns the named pages, in order. Names are overview, air,
+// trends, forecast, warnings and sensors.
+func PagesByName(fonts *widget.Fonts, names []string) ([]Page, error) {
+    var pages []Page
+
+    for _, name := range names {
+        switch strings.TrimSpace(name) {
+        case "overview":
+            pages = append(pages, &OverviewPage{Fonts: fonts})
+        case "air":
+            pages = append(pages, &AirQualityPage{Fonts: fonts})
+        case "trends":
+            pages = append(pages, &TrendsPage{Fonts: fonts})
+        case "forecast":
+            pages = append(pages, &ForecastPage{Fonts: fonts})
+        case "warnings":
+            pages = append(pages, &WarningsPage{Fonts: fonts})
+        case "sensors":
+            pages = append(pages, &DiagnosticsPage{Fonts: fonts})
+        default:
+            return nil, fmt.Errorf("unknown page %q", name)
+        }
+    }
+
+    return pages, nil
+}
+
+// SetLayout changes the pages and how often they rotate while Run is
+// running, without setting up the panel again. The first page is shown once
+// any refresh in progress is done; SetLayout doesn't wait for it.
+func (m *Manager) SetLayout(pages []Page, rotate time.Duration) {
+    for {
+        select {
+        case m.changes <- layout{pages, rotate}:
+            return
+        default:
+            // replace a change Run hasn't picked up yet
+            select {
+            case <-m.changes:
+            default:
+            }
+        }
+    }
+}
+
+// Stop makes Run return and waits for it, so the panel is free once Stop
+// returns. Run must have been started.
+func (m *Manager) Stop() {
+    m.stopOnce.Do(func() { close(m.stop) })
+    <-m.done
+}
+
+// Run refreshes the display until presses is closed or Stop is called.
+// Button n shows page n.
+func (m *Manager) Run(data func() Data, presses <-chan int) {
+
+    defer close(m.done)
+
+    if len(m.Pages) == 0 {
+        return
+    }
+
+    var rotate, redraw <-chan time.Time
+
+    var rotateTicker *time.Ticker
+    if m.Rotate > 0 {
+        rotateTicker = time.NewTicker(m.Rotate)
+        rotate = rotateTicker.C
+    }
+    defer func() {
+        if rotateTicker != nil {
+            rotateTicker.Stop()
+        }
+    }()
+
+    if m.Redraw > 0 {
+        ticker := time.NewTicker(m.Redraw)
+        defer ticker.Stop()
+        redraw = ticker.C
+    }
+
+    pending := true
+    var wait <-chan time.Time
+
+    for {
+        if pending && wait == nil {
+            delay := m.MinInterval - time.Since(m.lastRefresh)
+            if delay <= 0 {
+                err := m.refresh(data())
+                // a frame the panel couldn't take yet is retried after
+                // MinInterval instead of waiting for the next redraw
+                pending = deferred(err)
+                if pending {
+                    wait = time.After(m.MinInterval)
+                }
+            } else {
+                wait = time.After(delay)
+            }
+        }
+
+        select {
+        case button, ok := <-presses:
+            if !ok {
+                return
+            }
+            if button >= 0 && button < len(m.Pages) {
+                m.current = button
+                pending = true
+            }
+
+        case <-rotate:
+            m.current = (m.current + 1) % len(m.Pages)
+            pending = true
+
+        case <-redraw:
+            pending = true
+
+        case <-wait:
+            wait = nil
+
+        case change := <-m.changes:
+            if rotateTicker != nil {
+                rotateTicker.Stop()
+                rotateTicker, rotate = nil, nil
+            }
+            if change.rotate > 0 {
+                rotateTicker = time.NewTicker(change.rotate)
+                rotate = rotateTicker.C
+            }
+
+            m.Rotate = change.rotate
+            if len(change.pages) > 0 {
+                m.Pages = change.pages
+                m.current = 0
+                pending = true
+            }
+
+        case <-m.stop:
+            return
+        }
+    }
+}
+
+// deferred reports whether err means the panel left the frame to be sent
+// again later, rather than that it can't be drawn.
+func deferred(err error) bool {
+    return errors.Is(err, uc8159.ErrTemperatureRange) || errors.Is(err, uc8159.ErrBusyTimeout)
+}
+
+func (m *Manager) refresh(data Data) error {
+
+    page := m.Pages[m.current]
+
+    if data.Now.IsZero() {
+        data.Now = time.Now()
+    }
+
+    if data.HasAmbient {
+        m.Display.SetAmbientTemperature(data.Ambient)
+    }
+
+    canvas := image.NewRGBA(image.Rect(0, 0, int(m.Display.Width()), int(m.Display.Height())))
+    draw.Draw(canvas, canvas.Bounds(), image.NewUniform(widget.White), image.Point{}, draw.Src)
+
+    err := page.Draw(canvas, &data)
+    if err == nil {
+        m.Display.DrawImage(canvas, &m.Image)
+        err = m.Display.UpdateScreen()
+    }
+
+    m.lastRefresh = time.Now()
+
+    if m.OnRefresh != nil {
+        m.OnRefresh(page, err)
+    }
+    return err
+}
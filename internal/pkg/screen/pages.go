@@ -0,0 +1,325 @@
+package screen
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "strings"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+    "github.com/tony-tsang/airmon/internal/pkg/widget"
+)
+
+const headerHeight = 40
+
+// painter draws text and keeps the first error, so a page can draw all its
+// parts and check once at the end.
+type painter struct {
+    dst   draw.Image
+    fonts *widget.Fonts
+    err   error
+}
+
+func (p *painter) text(s string, rect image.Rectangle, size float64, c color.Color, align widget.Align) {
+    if p.err != nil || s == "" {
+        return
+    }
+
+    _, p.err = p.fonts.DrawText(p.dst, s, widget.TextBox{
+        Rect:        rect,
+        Size:        size,
+        MinSize:     size * 0.6,
+        Color:       c,
+        Align:       align,
+        LineSpacing: 1.1,
+    })
+}
+
+func (p *painter) chart(err error) {
+    if p.err == nil {
+        p.err = err
+    }
+}
+
+// header draws the page title and time across the top, returning the area
+// below it.
+func (p *painter) header(title string, now time.Time) image.Rectangle {
+    bounds := p.dst.Bounds()
+    bar := image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+headerHeight)
+
+    draw.Draw(p.dst, bar, image.NewUniform(widget.Black), image.Point{}, draw.Src)
+
+    inner := bar.Inset(6)
+    p.text(title, inner, 24, widget.White, widget.AlignLeft)
+    p.text(now.Format("Jan 2 15:04"), inner, 24, widget.White, widget.AlignRight)
+
+    return image.Rect(bounds.Min.X, bar.Max.Y, bounds.Max.X, bounds.Max.Y).Inset(8)
+}
+
+// pmColor grades PM2.5 concentrations, in µg/m³.
+func pmColor(value float64) color.Color {
+    switch {
+    case value <= 15:
+        return widget.Green
+    case value <= 35:
+        return widget.Yellow
+    case value <= 55:
+        return widget.Orange
+    }
+    return widget.Red
+}
+
+// OverviewPage shows the current indoor and outdoor conditions.
+type OverviewPage struct {
+    Fonts *widget.Fonts
+}
+
+func (o *OverviewPage) Name() string {
+    return "Overview"
+}
+
+func (o *OverviewPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: o.Fonts}
+    area := p.header(o.Name(), data.Now)
+    weather := data.Weather
+
+    iconRect := image.Rect(area.Min.X, area.Min.Y, area.Min.X+128, area.Min.Y+128)
+    widget.DrawIcon(dst, iconRect, widget.HKOIcon(weather.Icon, widget.IsNight(data.Now)))
+
+    right := image.Rect(iconRect.Max.X+16, area.Min.Y, area.Max.X, area.Min.Y+64)
+    indoor := weather.IndoorData
+    p.text(fmt.Sprintf("%.1f℃", indoor.Temperature), right, 56, widget.Black, widget.AlignLeft)
+
+    right = right.Add(image.Pt(0, 64))
+    right.Max.Y = right.Min.Y + 32
+    p.text(fmt.Sprintf("%.0f%%  %.0f hPa", indoor.Humidity, indoor.Pressure), right, 28, widget.Blue, widget.AlignLeft)
+
+    right = right.Add(image.Pt(0, 36))
+    outdoor := weather.OutdoorData
+    p.text(fmt.Sprintf("Outdoor %.0f℃ %.0f%%", outdoor.Temperature, outdoor.Humidity), right, 24, widget.Black, widget.AlignLeft)
+
+    row := image.Rect(area.Min.X, iconRect.Max.Y+8, area.Max.X, iconRect.Max.Y+44)
+    p.text(fmt.Sprintf("PM2.5 %.0f µg/m³", weather.PM25), row, 28, pmColor(weather.PM25), widget.AlignLeft)
+
+    if data.History != nil {
+        sparkRect := image.Rect(area.Min.X+area.Dx()/2, row.Min.Y, area.Max.X, row.Max.Y)
+        samples := data.History.Range(history.Temperature, data.Now.Add(-24*time.Hour), data.Now)
+        widget.Sparkline(dst, sparkRect, samples, widget.Red)
+    }
+
+    if weather.Warnings != "" {
+        warnings := image.Rect(area.Min.X, row.Max.Y+8, area.Max.X, area.Max.Y)
+        p.text(weather.Warnings, warnings, 22, widget.Red, widget.AlignLeft)
+    }
+
+    return p.err
+}
+
+// AirQualityPage shows the particulate readings and the PM2.5 trend.
+type AirQualityPage struct {
+    Fonts *widget.Fonts
+}
+
+func (a *AirQualityPage) Name() string {
+    return "Air quality"
+}
+
+func (a *AirQualityPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: a.Fonts}
+    area := p.header(a.Name(), data.Now)
+    weather := data.Weather
+
+    readings := []struct {
+        label string
+        value float64
+    }{
+        {"PM1.0", weather.PM10},
+        {"PM2.5", weather.PM25},
+        {"PM10", weather.PM100},
+    }
+
+    column := area.Dx() / len(readings)
+    for i, r := range readings {
+        x := area.Min.X + i*column
+        p.text(r.label, image.Rect(x, area.Min.Y, x+column, area.Min.Y+28), 24, widget.Black, widget.AlignCenter)
+        p.text(fmt.Sprintf("%.0f", r.value), image.Rect(x, area.Min.Y+28, x+column, area.Min.Y+100), 64, pmColor(r.value), widget.AlignCenter)
+    }
+
+    if data.History != nil {
+        chart := image.Rect(area.Min.X, area.Min.Y+110, area.Max.X, area.Max.Y)
+        from := data.Now.Add(-24 * time.Hour)
+        p.chart(widget.LineChart(dst, chart, data.History.Range(history.PM25, from, data.Now), widget.ChartOptions{
+            From:  from,
+            To:    data.Now,
+            Line:  widget.Red,
+            Band:  widget.Orange,
+            Fonts: a.Fonts,
+        }))
+    }
+
+    return p.err
+}
+
+// TrendsPage charts the indoor humidity over the last day, the pressure over
+// the last three days and the hourly rainfall over the last day.
+type TrendsPage struct {
+    Fonts *widget.Fonts
+}
+
+func (t *TrendsPage) Name() string {
+    return "Trends"
+}
+
+func (t *TrendsPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: t.Fonts}
+    area := p.header(t.Name(), data.Now)
+
+    if data.History == nil {
+        return p.err
+    }
+
+    day := data.Now.Add(-24 * time.Hour)
+    charts := []struct {
+        title string
+        draw  func(rect image.Rectangle) error
+    }{
+        {"Humidity, 24 hours", func(rect image.Rectangle) error {
+            return widget.LineChart(dst, rect, data.History.Range(history.Humidity, day, data.Now), widget.ChartOptions{
+                From:   day,
+                To:     data.Now,
+                Line:   widget.Blue,
+                Band:   widget.Green,
+                Fonts:  t.Fonts,
+                Format: "%.0f%%",
+            })
+        }},
+        {"Pressure, 3 days", func(rect image.Rectangle) error {
+            from := data.Now.Add(-72 * time.Hour)
+            return widget.LineChart(dst, rect, data.History.Range(history.Pressure, from, data.Now), widget.ChartOptions{
+                From:       from,
+                To:         data.Now,
+                Line:       widget.Black,
+                Fonts:      t.Fonts,
+                TimeFormat: "Jan 2",
+            })
+        }},
+        {"Rainfall, mm an hour", func(rect image.Rectangle) error {
+            return widget.BarChart(dst, rect, data.History.Range(history.Rainfall, day, data.Now), widget.ChartOptions{
+                From:    day,
+                To:      data.Now,
+                Buckets: 24,
+                Line:    widget.Blue,
+                Fonts:   t.Fonts,
+            })
+        }},
+    }
+
+    height := area.Dy() / len(charts)
+    for i, chart := range charts {
+        top := area.Min.Y + i*height
+        p.text(chart.title, image.Rect(area.Min.X, top, area.Max.X, top+22), 18, widget.Black, widget.AlignLeft)
+        p.chart(chart.draw(image.Rect(area.Min.X, top+24, area.Max.X, top+height-6)))
+    }
+
+    return p.err
+}
+
+// ForecastPage shows the next five days and the Observatory's local weather
+// forecast.
+type ForecastPage struct {
+    Fonts *widget.Fonts
+}
+
+func (f *ForecastPage) Name() string {
+    return "Forecast"
+}
+
+func (f *ForecastPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: f.Fonts}
+    area := p.header(f.Name(), data.Now)
+    weather := data.Weather
+
+    if len(weather.DailyForecast) > 0 {
+        strip := image.Rect(area.Min.X, area.Min.Y, area.Max.X, area.Min.Y+130)
+        p.chart(widget.ForecastStrip(dst, strip, weather.DailyForecast, 5, f.Fonts))
+        area.Min.Y = strip.Max.Y + 8
+    }
+
+    third := (area.Dy() - 30) / 3
+
+    p.text(weather.GeneralSituation, image.Rect(area.Min.X, area.Min.Y, area.Max.X, area.Min.Y+third), 22, widget.Black, widget.AlignLeft)
+    p.text(weather.Forecast, image.Rect(area.Min.X, area.Min.Y+third, area.Max.X, area.Min.Y+2*third), 22, widget.Blue, widget.AlignLeft)
+    p.text(weather.Outlook, image.Rect(area.Min.X, area.Min.Y+2*third, area.Max.X, area.Max.Y-30), 20, widget.Black, widget.AlignLeft)
+    p.text(fmt.Sprintf("Rainfall %.0f mm", weather.Rainfall), image.Rect(area.Min.X, area.Max.Y-28, area.Max.X, area.Max.Y), 22, widget.Blue, widget.AlignLeft)
+
+    return p.err
+}
+
+// WarningsPage shows the full text of the warnings in force and any special
+// weather tips.
+type WarningsPage struct {
+    Fonts *widget.Fonts
+}
+
+func (w *WarningsPage) Name() string {
+    return "Warnings"
+}
+
+func (w *WarningsPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: w.Fonts}
+    area := p.header(w.Name(), data.Now)
+    weather := data.Weather
+
+    if len(weather.WarningDetails) == 0 && len(weather.SpecialWeatherTips) == 0 {
+        p.text("No warnings in force", area, 28, widget.Green, widget.AlignLeft)
+        return p.err
+    }
+
+    split := area.Max.Y
+    if len(weather.SpecialWeatherTips) > 0 && len(weather.WarningDetails) > 0 {
+        split = area.Min.Y + area.Dy()*2/3
+    }
+
+    if len(weather.WarningDetails) > 0 {
+        p.text(strings.Join(weather.WarningDetails, "\n"), image.Rect(area.Min.X, area.Min.Y, area.Max.X, split), 22, widget.Red, widget.AlignLeft)
+    } else {
+        split = area.Min.Y
+    }
+
+    p.text(strings.Join(weather.SpecialWeatherTips, "\n"), image.Rect(area.Min.X, split, area.Max.X, area.Max.Y), 20, widget.Orange, widget.AlignLeft)
+
+    return p.err
+}
+
+// DiagnosticsPage lists when each sensor last reported.
+type DiagnosticsPage struct {
+    Fonts *widget.Fonts
+}
+
+func (d *DiagnosticsPage) Name() string {
+    return "Sensors"
+}
+
+func (d *DiagnosticsPage) Draw(dst draw.Image, data *Data) error {
+    p := &painter{dst: dst, fonts: d.Fonts}
+    area := p.header(d.Name(), data.Now)
+
+    var lines []string
+    for _, sensor := range data.Sensors {
+        seen := "never"
+        if !sensor.LastSeen.IsZero() {
+            seen = data.Now.Sub(sensor.LastSeen).Round(time.Second).String() + " ago"
+        }
+        lines = append(lines, fmt.Sprintf("%s: %s  %s", sensor.Name, seen, sensor.Detail))
+    }
+
+    if data.HasAmbient {
+        lines = append(lines, fmt.Sprintf("Display ambient %.1f℃", data.Ambient))
+    }
+
+    p.text(strings.Join(lines, "\n"), area, 22, widget.Black, widget.AlignLeft)
+
+    return p.err
+}
@@ -0,0 +1,149 @@
+// Package tca9548a drives the TCA9548A I2C multiplexer, which puts up to
+// eight sensors with the same address on one bus. Each channel is an
+// i2c.Bus of its own, so the sensor drivers work behind it unchanged.
+package tca9548a
+
+import (
+    "fmt"
+    "sync"
+
+    "periph.io/x/conn/v3/i2c"
+    "periph.io/x/conn/v3/physic"
+)
+
+const (
+    // DefaultAddr is the address with A0-A2 low. The others go up to 0x77.
+    DefaultAddr uint16 = 0x70
+    // CHANNELS is how many downstream channels the mux has.
+    CHANNELS = 8
+)
+
+// Exclusive is implemented by buses shared with other users, such as
+// i2cbus.Device, so selecting a channel and the transaction on it aren't
+// split by someone else's transaction.
+type Exclusive interface {
+    Exclusive(f func(bus i2c.Bus) error) error
+}
+
+// Mux is a TCA9548A on bus.
+type Mux struct {
+    bus  i2c.Bus
+    addr uint16
+
+    // held for each transaction, for buses that aren't Exclusive
+    mu sync.Mutex
+}
+
+func New(i2cBus i2c.Bus) *Mux {
+    return NewAt(i2cBus, DefaultAddr)
+}
+
+func NewAt(i2cBus i2c.Bus, addr uint16) *Mux {
+    return &Mux{bus: i2cBus, addr: addr}
+}
+
+func (m *Mux) String() string {
+    return fmt.Sprintf("%s/tca9548a@0x%02x", m.bus, m.addr)
+}
+
+// Channel returns downstream channel n, 0 to 7.
+func (m *Mux) Channel(n int) (*Channel, error) {
+    if n < 0 || n >= CHANNELS {
+        return nil, fmt.Errorf("tca9548a: no channel %d, there are %d", n, CHANNELS)
+    }
+    return &Channel{mux: m, n: n}, nil
+}
+
+// Select connects only channel n, or none if n is -1.
+func (m *Mux) Select(n int) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return m.selectOn(m.bus, n)
+}
+
+func (m *Mux) selectOn(bus i2c.Bus, n int) error {
+    var mask byte
+    if n >= 0 {
+        mask = 1 << n
+    }
+
+    err := bus.Tx(m.addr, []byte{mask}, nil)
+    switch {
+    case err != nil && n < 0:
+        return fmt.Errorf("tca9548a: disconnecting channels: %w", err)
+    case err != nil:
+        return fmt.Errorf("tca9548a: selecting channel %d: %w", n, err)
+    }
+    return nil
+}
+
+// Channel is one downstream channel of a Mux. Each transaction selects the
+// channel, runs and then disconnects it again, so the sensors behind it
+// never clash with those at the same address upstream or on other channels.
+type Channel struct {
+    mux *Mux
+    n   int
+    // the upstream bus transactions go through, if not the mux's own
+    via i2c.Bus
+}
+
+// On returns the channel with its transactions going through bus, another
+// handle on the bus the mux is on, such as a driver's own i2cbus.Device. The
+// driver's transactions are then queued and reported as its own, once,
+// rather than as the mux's.
+func (c *Channel) On(bus i2c.Bus) *Channel {
+    return &Channel{mux: c.mux, n: c.n, via: bus}
+}
+
+func (c *Channel) String() string {
+    return fmt.Sprintf("%s:%d", c.mux, c.n)
+}
+
+func (c *Channel) upstream() i2c.Bus {
+    if c.via != nil {
+        return c.via
+    }
+    return c.mux.bus
+}
+
+func (c *Channel) Tx(addr uint16, w, r []byte) error {
+    return c.exclusive(c.tx(addr, w, r))
+}
+
+// exclusive runs f with the upstream bus to itself.
+func (c *Channel) exclusive(f func(bus i2c.Bus) error) error {
+    c.mux.mu.Lock()
+    defer c.mux.mu.Unlock()
+
+    bus := c.upstream()
+    if exclusive, ok := bus.(Exclusive); ok {
+        return exclusive.Exclusive(f)
+    }
+    return f(bus)
+}
+
+func (c *Channel) tx(addr uint16, w, r []byte) func(bus i2c.Bus) error {
+    return func(bus i2c.Bus) error {
+        err := c.mux.selectOn(bus, c.n)
+        if err != nil {
+            return err
+        }
+
+        err = bus.Tx(addr, w, r)
+
+        deselectErr := c.mux.selectOn(bus, -1)
+        if err == nil {
+            err = deselectErr
+        }
+        return err
+    }
+}
+
+// SetSpeed changes the speed of the whole upstream bus, between other
+// transactions on it.
+func (c *Channel) SetSpeed(f physic.Frequency) error {
+    return c.exclusive(func(bus i2c.Bus) error {
+        return bus.SetSpeed(f)
+    })
+}
@@ -0,0 +1,128 @@
+package uc8159
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Rotation is the clockwise rotation, in degrees, applied when drawing into
+// the buffer. The panel itself is always written in its native orientation.
+type Rotation int
+
+const (
+    Rotate0   Rotation = 0
+    Rotate90  Rotation = 90
+    Rotate180 Rotation = 180
+    Rotate270 Rotation = 270
+)
+
+// Profile selects the controller init sequence.
+type Profile int
+
+const (
+    // ProfileUC8159 is used by the 4" and 5.7" ACeP panels.
+    ProfileUC8159 Profile = iota
+    // ProfileAC073TC1A is used by the 7.3" ACeP panel.
+    ProfileAC073TC1A
+)
+
+// Config describes a panel and how it is wired up. Width and Height are the
+// native resolution of the panel, before rotation.
+type Config struct {
+    ResetPin string
+    BusyPin  string
+    DCPin    string
+    CSPin    string
+    Width    int
+    Height   int
+    Rotation Rotation
+    Profile  Profile
+}
+
+// Impression4 is the Inky Impression 4" (640x400).
+var Impression4 = Config{
+    ResetPin: RESET_PIN,
+    BusyPin:  BUSY_PIN,
+    DCPin:    DC_PIN,
+    CSPin:    CS0_PIN,
+    Width:    640,
+    Height:   400,
+    Profile:  ProfileUC8159,
+}
+
+// Impression57 is the Inky Impression 5.7" (600x448).
+var Impression57 = Config{
+    ResetPin: RESET_PIN,
+    BusyPin:  BUSY_PIN,
+    DCPin:    DC_PIN,
+    CSPin:    CS0_PIN,
+    Width:    600,
+    Height:   448,
+    Profile:  ProfileUC8159,
+}
+
+// Impression73 is the Inky Impression 7.3" (800x480).
+var Impression73 = Config{
+    ResetPin: RESET_PIN,
+    BusyPin:  BUSY_PIN,
+    DCPin:    DC_PIN,
+    CSPin:    CS0_PIN,
+    Width:    800,
+    Height:   480,
+    Profile:  ProfileAC073TC1A,
+}
+
+// DefaultConfig is the board airmon was first built for.
+var DefaultConfig = Impression57
+
+// Width and Height are the resolution the driver was hard-coded to before
+// panels were configurable.
+//
+// Deprecated: use Config.Width and Config.Height, or Display.Width and
+// Display.Height.
+const (
+    Width  = 640
+    Height = 400
+)
+
+// PanelByName returns the preset called "4", "5.7" or "7.3".
+func PanelByName(name string) (Config, bool) {
+    switch strings.TrimSuffix(name, "\"") {
+    case "4":
+        return Impression4, true
+    case "5.7":
+        return Impression57, true
+    case "7.3":
+        return Impression73, true
+    }
+    return Config{}, false
+}
+
+func (c Config) validate() error {
+    switch c.Rotation {
+    case Rotate0, Rotate90, Rotate180, Rotate270:
+    default:
+        return fmt.Errorf("unsupported rotation %d", c.Rotation)
+    }
+
+    if c.Width <= 0 || c.Height <= 0 || c.Width%8 != 0 {
+        return fmt.Errorf("unsupported resolution %dx%d", c.Width, c.Height)
+    }
+
+    if c.Profile == ProfileUC8159 && resolutionSetting(c.Width, c.Height) < 0 {
+        return fmt.Errorf("resolution %dx%d is not supported by UC8159", c.Width, c.Height)
+    }
+
+    return nil
+}
+
+// resolutionSetting returns the PSR RES bits for the UC8159, or -1.
+func resolutionSetting(width int, height int) int {
+    switch {
+    case width == 600 && height == 448:
+        return 0b11
+    case width == 640 && height == 400:
+        return 0b10
+    }
+    return -1
+}
@@ -0,0 +1,261 @@
+package uc8159
+
+import (
+    "image"
+    "image/color"
+    "strings"
+
+    "github.com/makeworld-the-better-one/dither/v2"
+    xdraw "golang.org/x/image/draw"
+)
+
+// SaturatedPalette is the idealised palette, using the pure RGB primaries the
+// controller is asked to show. The index of each entry matches its Color.
+var SaturatedPalette = color.Palette{
+    color.RGBA{0, 0, 0, 0xFF},
+    color.RGBA{255, 255, 255, 0xFF},
+    color.RGBA{0, 255, 0, 0xFF},
+    color.RGBA{0, 0, 255, 0xFF},
+    color.RGBA{255, 0, 0, 0xFF},
+    color.RGBA{255, 255, 0, 0xFF},
+    color.RGBA{255, 140, 0, 0xFF},
+}
+
+// CalibratedPalette is the palette measured from a real panel. Dithering
+// against it gives a closer match to what the ink actually looks like.
+var CalibratedPalette = color.Palette{
+    color.RGBA{57, 48, 57, 0xFF},
+    color.RGBA{255, 255, 255, 0xFF},
+    color.RGBA{58, 91, 70, 0xFF},
+    color.RGBA{61, 59, 94, 0xFF},
+    color.RGBA{156, 72, 75, 0xFF},
+    color.RGBA{208, 190, 71, 0xFF},
+    color.RGBA{177, 106, 73, 0xFF},
+}
+
+type DitherMode int
+
+const (
+    DitherNone DitherMode = iota
+    DitherErrorDiffusion
+    DitherOrdered
+)
+
+// ImageOptions controls how an arbitrary image is converted to panel colours.
+// Background is used for letterboxing and for transparent areas of the image,
+// and is only honoured with BackgroundSet; otherwise they are white, as BLACK
+// is the zero Color.
+// With KeepPure set, pixels that are exactly one of the panel colours, such as
+// text and lines drawn by widgets, come out as that colour whatever the
+// dithering does around them.
+type ImageOptions struct {
+    Palette    color.Palette
+    Mode       DitherMode
+    Matrix     dither.ErrorDiffusionMatrix
+    Serpentine bool
+    BayerSize  uint
+    Strength   float32
+    Background    Color
+    BackgroundSet bool
+    KeepPure      bool
+}
+
+// DefaultImageOptions gives good results for photos and rendered widgets.
+var DefaultImageOptions = ImageOptions{
+    Palette:    CalibratedPalette,
+    Mode:       DitherErrorDiffusion,
+    Matrix:     dither.FloydSteinberg,
+    Serpentine: true,
+    BayerSize:  4,
+    Strength:   1.0,
+    KeepPure:   true,
+}
+
+var matrices = map[string]dither.ErrorDiffusionMatrix{
+    "floyd-steinberg": dither.FloydSteinberg,
+    "jarvis":          dither.JarvisJudiceNinke,
+    "atkinson":        dither.Atkinson,
+    "stucki":          dither.Stucki,
+    "burkes":          dither.Burkes,
+    "sierra":          dither.Sierra,
+    "sierra-lite":     dither.SierraLite,
+}
+
+// ParseDither parses a dither name as used on command lines, e.g. "atkinson",
+// "bayer" or "none", into opts.
+func ParseDither(name string, opts *ImageOptions) bool {
+    name = strings.ToLower(name)
+
+    switch name {
+    case "none":
+        opts.Mode = DitherNone
+        return true
+    case "bayer", "ordered":
+        opts.Mode = DitherOrdered
+        return true
+    }
+
+    matrix, ok := matrices[name]
+    if !ok {
+        return false
+    }
+
+    opts.Mode = DitherErrorDiffusion
+    opts.Matrix = matrix
+    return true
+}
+
+// ParsePalette returns the palette called "calibrated" or "saturated".
+func ParsePalette(name string) (color.Palette, bool) {
+    switch strings.ToLower(name) {
+    case "calibrated":
+        return CalibratedPalette, true
+    case "saturated":
+        return SaturatedPalette, true
+    }
+    return nil, false
+}
+
+// Fit scales src to fit within width x height keeping its aspect ratio, and
+// centres it on a background of the given colour.
+func Fit(src image.Image, width int, height int, background color.Color) *image.RGBA {
+    dst := image.NewRGBA(image.Rect(0, 0, width, height))
+    xdraw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, xdraw.Src)
+
+    bounds := src.Bounds()
+    srcW, srcH := bounds.Dx(), bounds.Dy()
+    if srcW == 0 || srcH == 0 {
+        return dst
+    }
+
+    if srcW == width && srcH == height {
+        xdraw.Draw(dst, dst.Bounds(), src, bounds.Min, xdraw.Over)
+        return dst
+    }
+
+    w, h := width, srcH*width/srcW
+    if h > height {
+        w, h = srcW*height/srcH, height
+    }
+
+    x, y := (width-w)/2, (height-h)/2
+    xdraw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), src, bounds, xdraw.Over, nil)
+
+    return dst
+}
+
+func (o *ImageOptions) ditherer(palette color.Palette) *dither.Ditherer {
+    if o.Mode == DitherNone {
+        return nil
+    }
+
+    d := dither.NewDitherer(palette)
+
+    strength := o.Strength
+    if strength == 0 {
+        strength = 1.0
+    }
+
+    if o.Mode == DitherOrdered {
+        size := o.BayerSize
+        if size == 0 {
+            size = 4
+        }
+        d.Mapper = dither.Bayer(size, size, strength)
+    } else {
+        matrix := o.Matrix
+        if matrix == nil {
+            matrix = dither.FloydSteinberg
+        }
+        d.Matrix = dither.ErrorDiffusionStrength(matrix, strength)
+        d.Serpentine = o.Serpentine
+    }
+
+    return d
+}
+
+// Quantize maps img onto the palette of opts without touching the display,
+// returning one palette index per pixel.
+func Quantize(img image.Image, width int, height int, opts *ImageOptions) *image.Paletted {
+    if opts == nil {
+        opts = &DefaultImageOptions
+    }
+
+    palette := opts.Palette
+    if palette == nil {
+        palette = CalibratedPalette
+    }
+
+    background := palette[WHITE]
+    if opts.BackgroundSet && int(opts.Background) < len(palette) {
+        background = palette[opts.Background]
+    }
+
+    fitted := Fit(img, width, height, background)
+
+    var paletted *image.Paletted
+
+    if d := opts.ditherer(palette); d != nil {
+        paletted = d.DitherPaletted(fitted)
+    } else {
+        paletted = image.NewPaletted(fitted.Bounds(), palette)
+        xdraw.Draw(paletted, paletted.Bounds(), fitted, image.Point{}, xdraw.Src)
+    }
+
+    if opts.KeepPure {
+        keepPure(paletted, fitted, palette)
+    }
+
+    return paletted
+}
+
+// keepPure restores pixels of src that exactly match a panel colour, either
+// in the saturated palette or the one being dithered against.
+func keepPure(dst *image.Paletted, src *image.RGBA, palette color.Palette) {
+
+    pure := make(map[color.RGBA]uint8)
+    for _, p := range []color.Palette{SaturatedPalette, palette} {
+        for i, c := range p {
+            r, g, b, a := c.RGBA()
+            pure[color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}] = uint8(i)
+        }
+    }
+
+    bounds := src.Bounds()
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            if index, ok := pure[src.RGBAAt(x, y)]; ok {
+                dst.SetColorIndex(x, y, index)
+            }
+        }
+    }
+}
+
+// DrawImage resizes, letterboxes and dithers img to the rotated size of the
+// display, then writes it into the display buffer. UpdateScreen still has to
+// be called to show it.
+func (d *Display) DrawImage(img image.Image, opts *ImageOptions) {
+    width, height := int(d.Width()), int(d.Height())
+    paletted := Quantize(img, width, height, opts)
+
+    pix := paletted.Pix
+
+    if d.config.Rotation != Rotate0 {
+        for y := 0; y < height; y++ {
+            row := pix[y*paletted.Stride : y*paletted.Stride+width]
+            for x, index := range row {
+                d.SetPixel(x, y, Color(index))
+            }
+        }
+        return
+    }
+
+    // unrotated, so two neighbouring pixels can be packed straight into a byte
+    for y := 0; y < height; y++ {
+        row := pix[y*paletted.Stride : y*paletted.Stride+width]
+        out := d.buffer[y*width/2 : (y+1)*width/2]
+        for x := 0; x < width; x += 2 {
+            out[x/2] = (row[x]&0x0F)<<4 | (row[x+1] & 0x0F)
+        }
+    }
+}
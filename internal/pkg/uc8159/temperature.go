@@ -0,0 +1,186 @@
+package uc8159
+
+import (
+    "errors"
+    "fmt"
+    "math"
+
+    "periph.io/x/conn/v3/gpio"
+)
+
+// The panel doesn't refresh reliably outside this range, in degrees Celsius.
+const (
+    MinTemperature = 0.0
+    MaxTemperature = 40.0
+)
+
+// ErrTemperatureRange is returned by UpdateScreen when the ambient temperature
+// is outside MinTemperature and MaxTemperature.
+var ErrTemperatureRange = errors.New("uc8159: ambient temperature outside refresh range")
+
+// ErrNoResponse is returned by the reads when every bit comes back the same,
+// which is what a MISO line that isn't wired reads as.
+var ErrNoResponse = errors.New("uc8159: no response from the controller, is MISO wired?")
+
+// Status is the content of the FLG register.
+type Status byte
+
+const (
+    StatusNotBusy  Status = 1 << 0
+    StatusPowerOff Status = 1 << 1
+    StatusPowerOn  Status = 1 << 2
+    StatusData     Status = 1 << 3
+    StatusI2CBusy  Status = 1 << 4
+    StatusI2CError Status = 1 << 5
+    StatusPartial  Status = 1 << 6
+)
+
+// TSE settings. The controller picks its waveform by temperature, measured
+// by its own sensor until an ambient temperature is given, and from then on
+// forced to the value sent with TSSET.
+const (
+    tseInternal = 0x00
+    tseForced   = 0x80
+)
+
+// Status flag names, for metrics.
+var statusNames = []struct {
+    flag Status
+    name string
+}{
+    {StatusNotBusy, "not_busy"},
+    {StatusPowerOff, "power_off"},
+    {StatusPowerOn, "power_on"},
+    {StatusData, "data"},
+    {StatusI2CBusy, "i2c_busy"},
+    {StatusI2CError, "i2c_error"},
+    {StatusPartial, "partial"},
+}
+
+// Flags returns whether each flag is set, by name.
+func (s Status) Flags() map[string]bool {
+    flags := make(map[string]bool, len(statusNames))
+    for _, f := range statusNames {
+        flags[f.name] = s&f.flag != 0
+    }
+    return flags
+}
+
+// SetAmbientTemperature gives the display the room temperature, in degrees
+// Celsius, read from another sensor. It is sent to the controller before
+// each refresh, with TSE switched to the forced value, so the waveform is
+// picked for the room rather than by the controller's own sensor, and
+// refreshes are deferred while it is out of range.
+func (d *Display) SetAmbientTemperature(celsius float64) {
+    d.ambient = celsius
+    d.hasAmbient = true
+}
+
+// AmbientTemperature returns the last value given to SetAmbientTemperature.
+func (d *Display) AmbientTemperature() (float64, bool) {
+    return d.ambient, d.hasAmbient
+}
+
+// TemperatureSource is where the controller's temperature comes from:
+// "ambient" once SetAmbientTemperature has been called, and "internal", its
+// own sensor, until then.
+func (d *Display) TemperatureSource() string {
+    if d.hasAmbient {
+        return "ambient"
+    }
+    return "internal"
+}
+
+func (d *Display) sendTemperature() error {
+    if !d.hasAmbient {
+        return nil
+    }
+
+    celsius := math.Round(d.ambient)
+    return d.sendCommands([]command{
+        {TSE, []byte{tseForced}},
+        {TSSET, []byte{byte(int8(celsius))}},
+    })
+}
+
+// PanelTemperature reads the temperature the controller is using, from its
+// own sensor or, with an ambient temperature, the forced value. This needs
+// MISO to be wired to the panel, which not every board does; without it the
+// read fails with ErrNoResponse or, if the line floats, a value outside the
+// controller's operating range.
+func (d *Display) PanelTemperature() (float64, error) {
+    if !d.ready {
+        return 0, ErrNotInitialised
+    }
+
+    data, err := d.readCommand(TSR, 2)
+    if err != nil {
+        return 0, err
+    }
+
+    // whole degrees in the first byte, the top bit of the second is a half
+    celsius := float64(int8(data[0]))
+    if data[1]&0x80 != 0 {
+        celsius += 0.5
+    }
+
+    if celsius < -40 || celsius > 85 {
+        return 0, fmt.Errorf("uc8159: implausible panel temperature %.1f℃", celsius)
+    }
+    return celsius, nil
+}
+
+// Status reads the FLG register of the controller.
+func (d *Display) Status() (Status, error) {
+    if !d.ready {
+        return 0, ErrNotInitialised
+    }
+
+    data, err := d.readCommand(FLG, 1)
+    if err != nil {
+        return 0, err
+    }
+    return Status(data[0]), nil
+}
+
+func (d *Display) readCommand(cmd byte, length int) ([]byte, error) {
+
+    in := make([]byte, length)
+
+    err := d.cs.Out(gpio.Low)
+    if err != nil {
+        return nil, err
+    }
+    defer d.cs.Out(gpio.High)
+
+    err = d.dc.Out(SPI_COMMAND)
+    if err == nil {
+        err = d.spiBus.Tx([]byte{cmd}, nil)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("uc8159: command 0x%02X: %w", cmd, err)
+    }
+
+    err = d.dc.Out(SPI_DATA)
+    if err == nil {
+        err = d.spiBus.Tx(make([]byte, length), in)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("uc8159: reading 0x%02X: %w", cmd, err)
+    }
+
+    if sameBits(in) {
+        return nil, fmt.Errorf("uc8159: reading 0x%02X: %w", cmd, ErrNoResponse)
+    }
+    return in, nil
+}
+
+// sameBits reports whether data is all zero or all one bits.
+func sameBits(data []byte) bool {
+    for _, b := range data {
+        if b != data[0] {
+            return false
+        }
+    }
+    return len(data) > 0 && (data[0] == 0x00 || data[0] == 0xFF)
+}
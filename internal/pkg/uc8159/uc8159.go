@@ -1,7 +1,10 @@
 package uc8159
 
 import (
-    "log"
+    "bytes"
+    "errors"
+    "fmt"
+    "image"
     "time"
 
     "periph.io/x/conn/v3/gpio"
@@ -9,9 +12,6 @@ import (
     "periph.io/x/conn/v3/spi"
 )
 
-const Width = 640
-const Height = 400
-
 const (
     RESET_PIN = "GPIO27"
     BUSY_PIN  = "GPIO17"
@@ -47,6 +47,9 @@ const (
     POF   = 0x02
     PFS   = 0x03
     PON   = 0x04
+    BTST1 = 0x05
+    BTST2 = 0x06
+    BTST3 = 0x08
     DTM1  = 0x10
     DSP   = 0x11
     DRF   = 0x12
@@ -65,123 +68,278 @@ const (
     AMV   = 0x80
     VV    = 0x81
     VDCS  = 0x82
+    TVDCS = 0x84
+    AGID  = 0x86
+    PTL   = 0x90
+    PTIN  = 0x91
+    PTOUT = 0x92
+    CMDH  = 0xAA
+    CCSET = 0xE0
     PWS   = 0xE3
     TSSET = 0xE5
 )
 
-const bufSz = Width / 2 * Height
+// partialLimit is the largest share of the panel, in percent, that is sent
+// as a partial window update. Anything bigger gets a full refresh.
+const partialLimit = 50
+
+// ErrBusyTimeout is returned when the panel is still busy after the time a
+// command is expected to take.
+var ErrBusyTimeout = errors.New("uc8159: timed out waiting for busy flag")
+
+// ErrNotInitialised is returned when reading from the controller before the
+// first refresh has set it up.
+var ErrNotInitialised = errors.New("uc8159: display not initialised")
 
 type Display struct {
     spiBus   spi.Conn
+    config   Config
     buffer   []byte
+    pushed   []byte
     reset    gpio.PinIO
     busy     gpio.PinIO
     dc       gpio.PinIO
     cs       gpio.PinIO
     gpioInit bool
+    ready    bool
+    partial  bool
+
+    ambient    float64
+    hasAmbient bool
 }
 
+// Width returns the width of the display as seen by callers, i.e. after
+// rotation.
 func (d *Display) Width() uint16 {
-    return Width
+    if d.config.Rotation == Rotate90 || d.config.Rotation == Rotate270 {
+        return uint16(d.config.Height)
+    }
+    return uint16(d.config.Width)
 }
 
+// Height returns the height of the display after rotation.
 func (d *Display) Height() uint16 {
-    return Height
+    if d.config.Rotation == Rotate90 || d.config.Rotation == Rotate270 {
+        return uint16(d.config.Width)
+    }
+    return uint16(d.config.Height)
+}
+
+// Init sets up the display using DefaultConfig.
+func (d *Display) Init(spiBus spi.Conn) error {
+    return d.InitConfig(spiBus, DefaultConfig)
 }
 
-func (d *Display) Init(spiBus spi.Conn) {
+// InitConfig sets up the display for the panel and wiring described by cfg.
+func (d *Display) InitConfig(spiBus spi.Conn, cfg Config) error {
 
-    d.buffer = make([]byte, bufSz, bufSz)
+    if err := cfg.validate(); err != nil {
+        return err
+    }
+
+    d.config = cfg
+    d.buffer = make([]byte, cfg.Width/2*cfg.Height)
+    d.pushed = nil
     d.spiBus = spiBus
+    d.partial = cfg.Profile == ProfileUC8159
+    d.gpioInit = false
+    d.ready = false
 
+    return nil
 }
 
-func (d *Display) setup() {
+// SetPartialRefresh enables or disables partial window updates. When disabled
+// every change to the buffer refreshes the whole panel. Only UC8159 panels
+// support partial updates.
+func (d *Display) SetPartialRefresh(enabled bool) {
+    d.partial = enabled && d.config.Profile == ProfileUC8159
+}
 
-    if !d.gpioInit {
+// Invalidate forgets the last frame sent to the panel, so that the next
+// UpdateScreen does a full refresh even if nothing changed. Useful to clear
+// ghosting left by a run of partial updates.
+func (d *Display) Invalidate() {
+    d.pushed = nil
+}
 
-        d.reset = gpioreg.ByName(RESET_PIN)
-        if d.reset == nil {
-            log.Fatalf("Unable to find %s", RESET_PIN)
-        }
-        d.reset.Out(gpio.High)
+func (d *Display) setup() error {
 
-        d.dc = gpioreg.ByName(DC_PIN)
-        if d.dc == nil {
-            log.Fatalf("Unable to find %s", DC_PIN)
+    if !d.gpioInit {
+        err := d.setupGPIO()
+        if err != nil {
+            return err
         }
-        d.dc.Out(gpio.Low)
+        d.gpioInit = true
+    }
 
-        d.cs = gpioreg.ByName(CS0_PIN)
-        if d.cs == nil {
-            log.Fatalf("Unable to find %s", CS0_PIN)
-        }
-        d.cs.Out(gpio.High)
+    err := d.reset.Out(gpio.Low)
+    if err != nil {
+        return fmt.Errorf("uc8159: reset: %w", err)
+    }
+    time.Sleep(100 * time.Millisecond)
+    err = d.reset.Out(gpio.High)
+    if err != nil {
+        return fmt.Errorf("uc8159: reset: %w", err)
+    }
 
-        d.busy = gpioreg.ByName(BUSY_PIN)
-        if d.busy == nil {
-            log.Fatalf("Unable to find %s", BUSY_PIN)
-        }
-        d.busy.In(gpio.PullDown, gpio.NoEdge)
+    err = d.busyWait(1000)
+    if err != nil {
+        return err
+    }
 
-        d.gpioInit = true
+    switch d.config.Profile {
+    case ProfileAC073TC1A:
+        return d.setupAC073TC1A()
+    default:
+        return d.setupUC8159()
     }
+}
 
-    d.reset.Out(gpio.Low)
-    time.Sleep(100 * time.Millisecond)
-    d.reset.Out(gpio.High)
+func (d *Display) setupGPIO() error {
 
-    d.busyWait(1000)
+    var err error
 
-    cols, rows, resolutionSetting := Width, Height, 0b10
+    d.reset, err = outputPin(d.config.ResetPin, gpio.High)
+    if err != nil {
+        return err
+    }
 
-    d.sendCommand(TRES, []byte{
-        byte(cols >> 8),
-        byte(cols & 0x0F),
-        byte(rows >> 8),
-        byte(rows & 0x0F),
-    })
+    d.dc, err = outputPin(d.config.DCPin, gpio.Low)
+    if err != nil {
+        return err
+    }
 
-    d.sendCommand(PSR, []byte{
-        byte(resolutionSetting<<6 | 0b101111),
-        0x08,
-    })
+    d.cs, err = outputPin(d.config.CSPin, gpio.High)
+    if err != nil {
+        return err
+    }
 
-    d.sendCommand(PWR, []byte{
-        (0x6 << 3) | (0x01 << 2) | (0x01 << 1) | (0x01),
-        0x00,
-        0x23,
-        0x23,
-    })
+    d.busy = gpioreg.ByName(d.config.BusyPin)
+    if d.busy == nil {
+        return fmt.Errorf("uc8159: unable to find %s", d.config.BusyPin)
+    }
+    err = d.busy.In(gpio.PullDown, gpio.NoEdge)
+    if err != nil {
+        return fmt.Errorf("uc8159: %s: %w", d.config.BusyPin, err)
+    }
 
-    d.sendCommand(PLL, []byte{
-        0x3C,
-    })
+    return nil
+}
 
-    d.sendCommand(TSE, []byte{
-        0x00,
-    })
-    d.sendCommand(CDI, []byte{
-        0x1<<5 | 0x17,
-    })
-    d.sendCommand(TCON, []byte{
-        0x22,
-    })
-    d.sendCommand(DAM, []byte{
-        0x00,
-    })
-    d.sendCommand(PWS, []byte{
-        0xAA,
-    })
-    d.sendCommand(PFS, []byte{
-        0x00,
+func outputPin(name string, level gpio.Level) (gpio.PinIO, error) {
+
+    pin := gpioreg.ByName(name)
+    if pin == nil {
+        return nil, fmt.Errorf("uc8159: unable to find %s", name)
+    }
+
+    err := pin.Out(level)
+    if err != nil {
+        return nil, fmt.Errorf("uc8159: %s: %w", name, err)
+    }
+
+    return pin, nil
+}
+
+type command struct {
+    cmd  byte
+    data []byte
+}
+
+func (d *Display) sendCommands(commands []command) error {
+    for _, c := range commands {
+        err := d.sendCommand(c.cmd, c.data)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (d *Display) setupUC8159() error {
+
+    cols, rows := d.config.Width, d.config.Height
+    resolution := resolutionSetting(cols, rows)
+
+    return d.sendCommands([]command{
+        {TRES, []byte{
+            byte(cols >> 8),
+            byte(cols & 0xFF),
+            byte(rows >> 8),
+            byte(rows & 0xFF),
+        }},
+        {PSR, []byte{
+            byte(resolution<<6 | 0b101111),
+            0x08,
+        }},
+        {PWR, []byte{
+            (0x6 << 3) | (0x01 << 2) | (0x01 << 1) | (0x01),
+            0x00,
+            0x23,
+            0x23,
+        }},
+        {PLL, []byte{0x3C}},
+        {TSE, []byte{tseInternal}},
+        {CDI, []byte{0x1<<5 | 0x17}},
+        {TCON, []byte{0x22}},
+        {DAM, []byte{0x00}},
+        {PWS, []byte{0xAA}},
+        {PFS, []byte{0x00}},
     })
+}
 
+func (d *Display) setupAC073TC1A() error {
+
+    cols, rows := d.config.Width, d.config.Height
+
+    return d.sendCommands([]command{
+        {CMDH, []byte{0x49, 0x55, 0x20, 0x08, 0x09, 0x18}},
+        {PWR, []byte{0x3F, 0x00, 0x32, 0x2A, 0x0E, 0x2A}},
+        {PSR, []byte{0x5F, 0x69}},
+        {PFS, []byte{0x00, 0x54, 0x00, 0x44}},
+        {BTST1, []byte{0x40, 0x1F, 0x1F, 0x2C}},
+        {BTST2, []byte{0x6F, 0x1F, 0x16, 0x25}},
+        {BTST3, []byte{0x6F, 0x1F, 0x1F, 0x22}},
+        {IPC, []byte{0x00, 0x04}},
+        {PLL, []byte{0x02}},
+        {TSE, []byte{tseInternal}},
+        {CDI, []byte{0x3F}},
+        {TCON, []byte{0x02, 0x00}},
+        {TRES, []byte{
+            byte(cols >> 8),
+            byte(cols & 0xFF),
+            byte(rows >> 8),
+            byte(rows & 0xFF),
+        }},
+        {VDCS, []byte{0x1E}},
+        {TVDCS, []byte{0x00}},
+        {AGID, []byte{0x00}},
+        {PWS, []byte{0x2F}},
+        {CCSET, []byte{0x00}},
+        {TSSET, []byte{0x00}},
+    })
 }
 
+// SetPixel sets the pixel at x, y in rotated coordinates.
 func (d *Display) SetPixel(x int, y int, color Color) {
 
-    pos := int(Width/2)*y + int(x/2)
+    w, h := d.config.Width, d.config.Height
+
+    switch d.config.Rotation {
+    case Rotate90:
+        x, y = w-1-y, x
+    case Rotate180:
+        x, y = w-1-x, h-1-y
+    case Rotate270:
+        x, y = y, h-1-x
+    }
+
+    d.setNativePixel(x, y, color)
+}
+
+func (d *Display) setNativePixel(x int, y int, color Color) {
+
+    pos := d.config.Width/2*y + x/2
 
     if x&1 == 0 {
         // set high bits
@@ -193,14 +351,13 @@ func (d *Display) SetPixel(x int, y int, color Color) {
 }
 
 func (d *Display) Fill(color Color) {
-    for y := 0; y < 400; y++ {
-        for x := 0; x < 640; x++ {
-            d.SetPixel(x, y, color)
-        }
+    packed := (byte(color)&0x0F)<<4 | byte(color)&0x0F
+    for i := range d.buffer {
+        d.buffer[i] = packed
     }
 }
 
-func (d *Display) busyWait(milliseconds int) {
+func (d *Display) busyWait(milliseconds int) error {
     duration := time.Duration(milliseconds) * time.Millisecond
 
     //log.Printf("start busy wait")
@@ -220,76 +377,260 @@ func (d *Display) busyWait(milliseconds int) {
             current = time.Now()
             elapsed = current.Sub(start)
             if elapsed >= duration {
-                log.Printf("Wait duration expired, buy busy flag still Low")
-                return
+                return ErrBusyTimeout
             }
         }
     }
 
     //log.Printf("end busy wait")
+    return nil
 }
 
-func (d *Display) sendCommand(cmd byte, data []byte) {
+func (d *Display) sendCommand(cmd byte, data []byte) error {
 
     buf := []byte{cmd}
     // log.Printf("cmd = 0x%X", cmd)
-    d.spiWrite(SPI_COMMAND, buf)
+    err := d.spiWrite(SPI_COMMAND, buf)
+    if err != nil {
+        return fmt.Errorf("uc8159: command 0x%02X: %w", cmd, err)
+    }
 
     if data != nil {
-        d.spiWrite(SPI_DATA, data)
+        err = d.spiWrite(SPI_DATA, data)
+        if err != nil {
+            return fmt.Errorf("uc8159: command 0x%02X data: %w", cmd, err)
+        }
+    }
+
+    return nil
+}
+
+// sendCommandWait sends a command and waits up to milliseconds for the panel
+// to finish processing it.
+func (d *Display) sendCommandWait(cmd byte, data []byte, milliseconds int) error {
+    err := d.sendCommand(cmd, data)
+    if err != nil {
+        return err
     }
+    return d.busyWait(milliseconds)
 }
 
-func (d *Display) UpdateScreen() {
+// UpdateScreen shows the buffer on the panel. Frames identical to the last
+// one pushed are skipped, and small changes only refresh the changed window.
+// The controller is only reset and initialised on the first refresh.
+//
+// If the ambient temperature is outside the range the panel can refresh in,
+// ErrTemperatureRange is returned and the frame stays pending until the next
+// call.
+func (d *Display) UpdateScreen() error {
+
+    if d.pushed != nil && bytes.Equal(d.pushed, d.buffer) {
+        return nil
+    }
+
+    if d.hasAmbient && (d.ambient < MinTemperature || d.ambient > MaxTemperature) {
+        return ErrTemperatureRange
+    }
+
+    if !d.ready {
+        err := d.setup()
+        if err != nil {
+            return err
+        }
+        d.ready = true
+    }
+
+    err := d.sendTemperature()
+    if err != nil {
+        d.ready = false
+        return err
+    }
+
+    window, ok := d.dirtyWindow()
+    if ok && d.partial && window.Dx()*window.Dy()*100 <= len(d.buffer)*2*partialLimit {
+        err = d.partialUpdate(window)
+    } else {
+        err = d.fullUpdate()
+    }
+
+    if err != nil {
+        // reset the controller next time, it is in an unknown state
+        d.ready = false
+        return err
+    }
+
+    if d.pushed == nil {
+        d.pushed = make([]byte, len(d.buffer))
+    }
+    copy(d.pushed, d.buffer)
+
+    return nil
+}
 
-    d.setup()
+func (d *Display) fullUpdate() error {
 
     //log.Printf("sending DTM1")
-    d.sendCommand(DTM1, d.buffer)
-    d.busyWait(200)
+    err := d.sendCommandWait(DTM1, d.buffer, 200)
+    if err != nil {
+        return err
+    }
 
-    // log.Printf("sending DSP")
-    // d.sendCommand(DSP, nil)
-    // d.busyWait(200)
+    return d.refresh()
+}
+
+func (d *Display) refresh() error {
 
     //log.Printf("sending PON")
-    d.sendCommand(PON, nil)
-    d.busyWait(200)
+    err := d.sendCommandWait(PON, nil, 200)
+    if err != nil {
+        return err
+    }
+
+    // the 7.3" controller takes a parameter byte and needs longer
+    var param []byte
+    timeout := 32000
+    if d.config.Profile == ProfileAC073TC1A {
+        param = []byte{0x00}
+        timeout = 45000
+    }
 
     //log.Printf("sending DRF")
-    d.sendCommand(DRF, nil)
-    d.busyWait(32000)
+    err = d.sendCommandWait(DRF, param, timeout)
+    if err != nil {
+        return err
+    }
 
     //log.Printf("sending POF")
-    d.sendCommand(POF, nil)
-    d.busyWait(200)
+    return d.sendCommandWait(POF, param, 200)
 }
 
-func (d *Display) spiWrite(dc gpio.Level, data []byte) {
+// partialUpdate sends only the pixels inside window, which must be aligned
+// to 8 pixels horizontally, and refreshes that part of the panel.
+func (d *Display) partialUpdate(window image.Rectangle) error {
 
-    d.cs.Out(gpio.Low)
+    hrst, hred := window.Min.X, window.Max.X-1
+    vrst, vred := window.Min.Y, window.Max.Y-1
 
-    d.dc.Out(dc)
+    rowBytes := window.Dx() / 2
+    data := make([]byte, 0, rowBytes*window.Dy())
+    for y := window.Min.Y; y < window.Max.Y; y++ {
+        start := y*d.config.Width/2 + window.Min.X/2
+        data = append(data, d.buffer[start:start+rowBytes]...)
+    }
 
-    i := 0
+    err := d.sendCommands([]command{
+        {PTIN, nil},
+        {PTL, []byte{
+            byte(hrst >> 8),
+            byte(hrst & 0xF8),
+            byte(hred >> 8),
+            byte(hred&0xF8 | 0x07),
+            byte(vrst >> 8),
+            byte(vrst & 0xFF),
+            byte(vred >> 8),
+            byte(vred & 0xFF),
+            0x01,
+        }},
+    })
+    if err != nil {
+        return err
+    }
 
-    for {
+    err = d.sendCommandWait(DTM1, data, 200)
+    if err == nil {
+        err = d.refresh()
+    }
 
-        var end int
+    // always leave partial mode, even when the refresh failed
+    outErr := d.sendCommand(PTOUT, nil)
+    if err != nil {
+        return err
+    }
 
-        if (i + SPI_CHUNK_SIZE) > len(data) {
-            end = len(data)
-        } else {
-            end = i + SPI_CHUNK_SIZE
+    return outErr
+}
+
+// dirtyWindow returns the smallest 8 pixel aligned rectangle covering every
+// byte that differs from the last pushed frame.
+func (d *Display) dirtyWindow() (image.Rectangle, bool) {
+
+    if d.pushed == nil {
+        return image.Rectangle{}, false
+    }
+
+    width, height := d.config.Width, d.config.Height
+    rowBytes := width / 2
+    minX, maxX, minY, maxY := rowBytes, -1, height, -1
+
+    for y := 0; y < height; y++ {
+        row := d.buffer[y*rowBytes : (y+1)*rowBytes]
+        old := d.pushed[y*rowBytes : (y+1)*rowBytes]
+        if bytes.Equal(row, old) {
+            continue
         }
 
-        d.spiBus.Tx(data[i:end], nil)
+        if y < minY {
+            minY = y
+        }
+        maxY = y
+
+        for x := 0; x < rowBytes; x++ {
+            if row[x] != old[x] {
+                if x < minX {
+                    minX = x
+                }
+                break
+            }
+        }
+        for x := rowBytes - 1; x >= 0; x-- {
+            if row[x] != old[x] {
+                if x > maxX {
+                    maxX = x
+                }
+                break
+            }
+        }
+    }
+
+    if maxY < 0 {
+        return image.Rectangle{}, false
+    }
+
+    // one byte holds two pixels and the window is set in 8 pixel steps
+    x0 := (minX * 2) &^ 7
+    x1 := ((maxX+1)*2 + 7) &^ 7
+    if x1 > width {
+        x1 = width
+    }
+
+    return image.Rect(x0, minY, x1, maxY+1), true
+}
+
+func (d *Display) spiWrite(dc gpio.Level, data []byte) error {
+
+    err := d.cs.Out(gpio.Low)
+    if err != nil {
+        return err
+    }
+    defer d.cs.Out(gpio.High)
+
+    err = d.dc.Out(dc)
+    if err != nil {
+        return err
+    }
+
+    for i := 0; i < len(data); i += SPI_CHUNK_SIZE {
+
+        end := i + SPI_CHUNK_SIZE
+        if end > len(data) {
+            end = len(data)
+        }
 
-        i += SPI_CHUNK_SIZE
-        if i > len(data) {
-            break
+        err = d.spiBus.Tx(data[i:end], nil)
+        if err != nil {
+            return fmt.Errorf("chunk at %d: %w", i, err)
         }
     }
 
-    d.cs.Out(gpio.High)
+    return nil
 }
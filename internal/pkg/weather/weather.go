@@ -0,0 +1,104 @@
+// Package weather is the interface between airmon and its sources of outdoor
+// weather, so the rest of airmon doesn't depend on any one of them.
+package weather
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/tony-tsang/airmon/internal/pkg"
+)
+
+// Reading is a value and where it was measured, as a key that doesn't
+// change with the provider's language. A Reading with no Place is missing.
+type Reading struct {
+    Place string
+    Value float64
+}
+
+func (r Reading) Valid() bool {
+    return r.Place != ""
+}
+
+// Warning is a weather warning or signal in force, such as Code "WTCSGNL"
+// and Subtype "TC8NE" for the No. 8 north-east gale or storm signal.
+type Warning struct {
+    Code    string
+    Subtype string
+    Name    string
+    Details string
+}
+
+// Report is the latest outdoor weather from a Provider. Providers fill in
+// what they have, so anything may be missing.
+type Report struct {
+    Source   string
+    Observed time.Time
+    // Fetched is when the report was fetched, and Stale is set if it is an
+    // earlier report because the latest fetch failed.
+    Fetched time.Time
+    Stale   bool
+
+    Temperature Reading
+    Humidity    Reading
+    Pressure    Reading
+    Rainfall    Reading
+    UVIndex     Reading
+
+    // Icon is an HKO weather icon number, which other providers map their
+    // conditions onto, or 0 if unknown.
+    Icon int
+
+    GeneralSituation   string
+    Forecast           string
+    Outlook            string
+    Messages           []string
+    Warnings           []Warning
+    SpecialWeatherTips []string
+    DailyForecast      []pkg.DayForecast
+}
+
+// Provider fetches reports. If a fetch fails, Fetch may return an earlier
+// report marked Stale along with the error; the report is zero if there is
+// nothing to return.
+type Provider interface {
+    Name() string
+    Fetch(ctx context.Context) (Report, error)
+}
+
+// DoLoop fetches a report every sleep and sends it on reports, until ctx is
+// cancelled. Each fetch, however many requests it makes, is given at most
+// sleep to finish so a slow source can't hold up the next one.
+func DoLoop(ctx context.Context, provider Provider, reports chan<- Report, sleep time.Duration) {
+
+    for {
+
+        report, err := fetch(ctx, provider, sleep)
+        if err != nil && ctx.Err() == nil {
+            log.Printf("Error fetching weather from %s: %v", provider.Name(), err)
+        }
+        if !report.Fetched.IsZero() {
+            select {
+            case reports <- report:
+            case <-ctx.Done():
+                return
+            }
+        }
+
+        select {
+        case <-time.After(sleep):
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+func fetch(ctx context.Context, provider Provider, timeout time.Duration) (Report, error) {
+    if timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+    return provider.Fetch(ctx)
+}
@@ -1,18 +1,35 @@
 package pkg
 
+import "time"
+
 type GeneralData struct {
 	Temperature float64
 	Humidity    float64
 	Pressure    float64
 }
 
+// DayForecast is one day of a multi-day forecast.
+type DayForecast struct {
+	Date    time.Time
+	Icon    int
+	MinTemp float64
+	MaxTemp float64
+	Weather string
+}
+
 type WeatherData struct {
-	PM100            float64
-	PM25             float64
-	PM10             float64
-	IndoorData       GeneralData
-	OutdoorData      GeneralData
-	GeneralSituation string
-	Warnings         string
-	Rainfall         float64
+	PM100              float64
+	PM25               float64
+	PM10               float64
+	IndoorData         GeneralData
+	OutdoorData        GeneralData
+	GeneralSituation   string
+	Forecast           string
+	Outlook            string
+	Warnings           string
+	WarningDetails     []string
+	SpecialWeatherTips []string
+	Rainfall           float64
+	Icon               int
+	DailyForecast      []DayForecast
 }
@@ -0,0 +1,350 @@
+package widget
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+    "time"
+
+    "golang.org/x/image/font"
+
+    "github.com/tony-tsang/airmon/internal/pkg/history"
+)
+
+// ChartOptions controls the chart widgets. Samples between From and To are
+// averaged into Buckets columns, by default one for every 4 pixels. The
+// value axis runs from Min to Max if Min < Max, otherwise it is scaled to the
+// data. Labels are only drawn if Fonts is set. Colours default to the pure
+// panel colours, which come through dithering untouched.
+type ChartOptions struct {
+    From       time.Time
+    To         time.Time
+    Buckets    int
+    Line       color.Color
+    Band       color.Color
+    Axis       color.Color
+    Fonts      *Fonts
+    LabelSize  float64
+    Format     string
+    TimeFormat string
+    Min        float64
+    Max        float64
+}
+
+func (o *ChartOptions) defaults(width int) {
+    if o.Buckets <= 0 {
+        o.Buckets = width / 4
+        if o.Buckets < 1 {
+            o.Buckets = 1
+        }
+    }
+    if o.Line == nil {
+        o.Line = Blue
+    }
+    if o.Axis == nil {
+        o.Axis = Black
+    }
+    if o.LabelSize <= 0 {
+        o.LabelSize = 14
+    }
+    if o.Format == "" {
+        o.Format = "%.0f"
+    }
+    if o.TimeFormat == "" {
+        o.TimeFormat = "15:04"
+    }
+}
+
+func (o *ChartOptions) buckets(samples []history.Sample) []history.Bucket {
+    step := o.To.Sub(o.From) / time.Duration(o.Buckets)
+    return history.Buckets(samples, o.From, step, o.Buckets)
+}
+
+// Sparkline draws a small line of the samples with no axes or labels, with a
+// dot on the latest value.
+func Sparkline(dst draw.Image, rect image.Rectangle, samples []history.Sample, c color.Color) {
+    if len(samples) == 0 || rect.Empty() {
+        return
+    }
+
+    if c == nil {
+        c = Black
+    }
+
+    from := samples[0].Time
+    to := samples[len(samples)-1].Time.Add(time.Second)
+
+    n := rect.Dx() / 2
+    if n < 1 {
+        n = 1
+    }
+
+    buckets := history.Buckets(samples, from, to.Sub(from)/time.Duration(n)+1, n)
+    low, high, ok := valueRange(buckets, false)
+    if !ok {
+        return
+    }
+
+    last := plotLine(dst, rect, buckets, low, high, c)
+    if last != nil {
+        fillRect(dst, image.Rect(last.X-2, last.Y-2, last.X+2, last.Y+2), c)
+    }
+}
+
+// LineChart draws the average of each bucket as a line, over a band from its
+// minimum to maximum if opts.Band is set, with labelled axes.
+func LineChart(dst draw.Image, rect image.Rectangle, samples []history.Sample, opts ChartOptions) error {
+    opts.defaults(rect.Dx())
+    buckets := opts.buckets(samples)
+
+    low, high, ok := opts.Min, opts.Max, opts.Min < opts.Max
+    if !ok {
+        low, high, ok = valueRange(buckets, opts.Band != nil)
+    }
+
+    plot, err := drawAxes(dst, rect, low, high, ok, &opts)
+    if err != nil || !ok || plot.Empty() {
+        return err
+    }
+
+    if opts.Band != nil {
+        for i, b := range buckets {
+            if b.Count == 0 {
+                continue
+            }
+            x0, x1 := columnSpan(plot, i, len(buckets))
+            y0 := scaleY(plot, b.Max, low, high)
+            y1 := scaleY(plot, b.Min, low, high)
+            fillRect(dst, image.Rect(x0, y0, x1, y1+1), opts.Band)
+        }
+    }
+
+    plotLine(dst, plot, buckets, low, high, opts.Line)
+    return nil
+}
+
+// BarChart draws the total of each bucket as a bar from zero, which suits
+// rainfall.
+func BarChart(dst draw.Image, rect image.Rectangle, samples []history.Sample, opts ChartOptions) error {
+    opts.defaults(rect.Dx())
+    buckets := opts.buckets(samples)
+
+    low, high := 0.0, opts.Max
+    if !(opts.Min < opts.Max) {
+        high = 0
+        for _, b := range buckets {
+            high = math.Max(high, b.Sum)
+        }
+    }
+    if high <= low {
+        high = low + 1
+    }
+
+    plot, err := drawAxes(dst, rect, low, high, true, &opts)
+    if err != nil || plot.Empty() {
+        return err
+    }
+
+    for i, b := range buckets {
+        if b.Count == 0 || b.Sum <= 0 {
+            continue
+        }
+        x0, x1 := columnSpan(plot, i, len(buckets))
+        if x1-x0 > 2 {
+            x1--
+        }
+        y := scaleY(plot, b.Sum, low, high)
+        fillRect(dst, image.Rect(x0, y, x1, plot.Max.Y), opts.Line)
+    }
+
+    return nil
+}
+
+type axisLabel struct {
+    text  string
+    rect  image.Rectangle
+    align Align
+}
+
+// drawAxes draws the axes and their labels, returning the area left for the
+// plot itself. Value labels are left out if hasRange is false.
+func drawAxes(dst draw.Image, rect image.Rectangle, low float64, high float64, hasRange bool, opts *ChartOptions) (image.Rectangle, error) {
+    plot := rect
+
+    lowLabel, highLabel := fmt.Sprintf(opts.Format, low), fmt.Sprintf(opts.Format, high)
+
+    if opts.Fonts != nil {
+        face, err := opts.Fonts.Face(opts.LabelSize)
+        if err != nil {
+            return image.Rectangle{}, err
+        }
+
+        labelWidth := 0
+        if hasRange {
+            labelWidth = font.MeasureString(face, lowLabel).Ceil()
+            if w := font.MeasureString(face, highLabel).Ceil(); w > labelWidth {
+                labelWidth = w
+            }
+        }
+
+        plot.Min.X += labelWidth + 4
+        plot.Max.Y -= face.Metrics().Height.Ceil() + 2
+    }
+
+    if plot.Empty() {
+        return plot, nil
+    }
+
+    // value axis on the left, time axis along the bottom
+    fillRect(dst, image.Rect(plot.Min.X-1, plot.Min.Y, plot.Min.X, plot.Max.Y), opts.Axis)
+    fillRect(dst, image.Rect(plot.Min.X-1, plot.Max.Y, plot.Max.X, plot.Max.Y+1), opts.Axis)
+
+    if opts.Fonts == nil {
+        return plot, nil
+    }
+
+    labels := []axisLabel{
+        {opts.From.Format(opts.TimeFormat), image.Rect(plot.Min.X, plot.Max.Y+2, plot.Max.X, rect.Max.Y), AlignLeft},
+        {opts.To.Format(opts.TimeFormat), image.Rect(plot.Min.X, plot.Max.Y+2, plot.Max.X, rect.Max.Y), AlignRight},
+    }
+
+    if hasRange {
+        labelHeight := int(math.Ceil(opts.LabelSize * 1.5))
+        labels = append(labels,
+            axisLabel{highLabel, image.Rect(rect.Min.X, plot.Min.Y, plot.Min.X-4, plot.Min.Y+labelHeight), AlignRight},
+            axisLabel{lowLabel, image.Rect(rect.Min.X, plot.Max.Y-labelHeight, plot.Min.X-4, plot.Max.Y), AlignRight},
+        )
+    }
+
+    for _, label := range labels {
+        _, err := opts.Fonts.DrawText(dst, label.text, TextBox{
+            Rect:  label.rect,
+            Size:  opts.LabelSize,
+            Color: opts.Axis,
+            Align: label.align,
+        })
+        if err != nil {
+            return plot, err
+        }
+    }
+
+    return plot, nil
+}
+
+// plotLine joins the means of the buckets, leaving gaps for empty ones, and
+// returns the last point drawn.
+func plotLine(dst draw.Image, plot image.Rectangle, buckets []history.Bucket, low float64, high float64, c color.Color) *image.Point {
+    var prev *image.Point
+
+    for i, b := range buckets {
+        if b.Count == 0 {
+            prev = nil
+            continue
+        }
+
+        x0, x1 := columnSpan(plot, i, len(buckets))
+        p := image.Point{(x0 + x1) / 2, scaleY(plot, b.Mean, low, high)}
+
+        if prev != nil {
+            drawLine(dst, *prev, p, c)
+        } else {
+            fillRect(dst, image.Rect(p.X, p.Y, p.X+2, p.Y+2), c)
+        }
+        prev = &p
+    }
+
+    return prev
+}
+
+func valueRange(buckets []history.Bucket, useExtremes bool) (float64, float64, bool) {
+    low, high := math.Inf(1), math.Inf(-1)
+
+    for _, b := range buckets {
+        if b.Count == 0 {
+            continue
+        }
+        if useExtremes {
+            low, high = math.Min(low, b.Min), math.Max(high, b.Max)
+        } else {
+            low, high = math.Min(low, b.Mean), math.Max(high, b.Mean)
+        }
+    }
+
+    if math.IsInf(low, 0) {
+        return 0, 0, false
+    }
+
+    if high-low < 1e-9 {
+        low, high = low-1, high+1
+    }
+
+    return low, high, true
+}
+
+func columnSpan(plot image.Rectangle, i int, n int) (int, int) {
+    x0 := plot.Min.X + i*plot.Dx()/n
+    x1 := plot.Min.X + (i+1)*plot.Dx()/n
+    if x1 <= x0 {
+        x1 = x0 + 1
+    }
+    return x0, x1
+}
+
+func scaleY(plot image.Rectangle, value float64, low float64, high float64) int {
+    frac := (value - low) / (high - low)
+    y := plot.Max.Y - 1 - int(math.Round(frac*float64(plot.Dy()-1)))
+    if y < plot.Min.Y {
+        y = plot.Min.Y
+    }
+    if y >= plot.Max.Y {
+        y = plot.Max.Y - 1
+    }
+    return y
+}
+
+func fillRect(dst draw.Image, rect image.Rectangle, c color.Color) {
+    draw.Draw(dst, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawLine draws a two pixel thick line without anti-aliasing, so it stays a
+// pure panel colour.
+func drawLine(dst draw.Image, p0 image.Point, p1 image.Point, c color.Color) {
+    dx := abs(p1.X - p0.X)
+    dy := -abs(p1.Y - p0.Y)
+    sx, sy := 1, 1
+    if p0.X > p1.X {
+        sx = -1
+    }
+    if p0.Y > p1.Y {
+        sy = -1
+    }
+
+    err := dx + dy
+    x, y := p0.X, p0.Y
+
+    for {
+        fillRect(dst, image.Rect(x, y, x+2, y+2), c)
+        if x == p1.X && y == p1.Y {
+            return
+        }
+
+        e2 := 2 * err
+        if e2 >= dy {
+            err += dy
+            x += sx
+        }
+        if e2 <= dx {
+            err += dx
+            y += sy
+        }
+    }
+}
+
+func abs(v int) int {
+    if v < 0 {
+        return -v
+    }
+    return v
+}
@@ -0,0 +1,55 @@
+package widget
+
+import (
+    "fmt"
+    "image"
+    "image/draw"
+
+    "github.com/tony-tsang/airmon/internal/pkg"
+)
+
+// ForecastStrip draws up to days forecasts side by side, each with its date,
+// icon and the maximum and minimum temperatures.
+func ForecastStrip(dst draw.Image, rect image.Rectangle, forecast []pkg.DayForecast, days int, fonts *Fonts) error {
+    if len(forecast) > days {
+        forecast = forecast[:days]
+    }
+    if len(forecast) == 0 || rect.Empty() {
+        return nil
+    }
+
+    const labelHeight = 26
+
+    column := rect.Dx() / len(forecast)
+
+    for i, day := range forecast {
+        cell := image.Rect(rect.Min.X+i*column, rect.Min.Y, rect.Min.X+(i+1)*column, rect.Max.Y).Inset(2)
+
+        dateRect := image.Rect(cell.Min.X, cell.Min.Y, cell.Max.X, cell.Min.Y+labelHeight)
+        tempRect := image.Rect(cell.Min.X, cell.Max.Y-labelHeight, cell.Max.X, cell.Max.Y)
+        iconRect := image.Rect(cell.Min.X, dateRect.Max.Y, cell.Max.X, tempRect.Min.Y)
+
+        _, err := fonts.DrawText(dst, day.Date.Format("Mon 2"), TextBox{Rect: dateRect, Size: 20, MinSize: 14, Color: Black, Align: AlignCenter})
+        if err != nil {
+            return err
+        }
+
+        DrawIcon(dst, iconRect, HKOIcon(day.Icon, false))
+
+        half := tempRect.Dx() / 2
+        maxRect := image.Rect(tempRect.Min.X, tempRect.Min.Y, tempRect.Min.X+half, tempRect.Max.Y)
+        minRect := image.Rect(maxRect.Max.X+4, tempRect.Min.Y, tempRect.Max.X, tempRect.Max.Y)
+
+        _, err = fonts.DrawText(dst, fmt.Sprintf("%.0f°", day.MaxTemp), TextBox{Rect: maxRect, Size: 20, MinSize: 14, Color: Red, Align: AlignRight})
+        if err != nil {
+            return err
+        }
+
+        _, err = fonts.DrawText(dst, fmt.Sprintf("%.0f°", day.MinTemp), TextBox{Rect: minRect, Size: 20, MinSize: 14, Color: Blue, Align: AlignLeft})
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,172 @@
+package widget
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "sync"
+    "time"
+
+    xdraw "golang.org/x/image/draw"
+
+    "github.com/tony-tsang/airmon/assets"
+)
+
+// FallbackIcon is shown for icon codes we don't know, or whose image is
+// missing from the bundle.
+const FallbackIcon = "unknown"
+
+// hkoIcons maps HKO weather icon numbers to the bundled icon set, which is
+// named like OpenWeatherMap's ("01" clear, "02" few clouds, "03" clouds,
+// "04" overcast, "09" showers, "10" sun and showers, "11" thunderstorm,
+// "13" cold, "50" mist), without the day or night suffix.
+var hkoIcons = map[int]string{
+    50: "01", // sunny
+    51: "02", // sunny periods
+    52: "02", // sunny intervals
+    53: "10", // sunny periods with a few showers
+    54: "10", // sunny intervals with showers
+    60: "03", // cloudy
+    61: "04", // overcast
+    62: "09", // light rain
+    63: "09", // rain
+    64: "09", // heavy rain
+    65: "11", // thunderstorms
+    70: "01", // fine, new moon
+    71: "01", // fine, waxing crescent
+    72: "01", // fine, first quarter
+    73: "01", // fine, full moon
+    74: "01", // fine, last quarter
+    75: "01", // fine, waning crescent
+    76: "03", // mainly cloudy
+    77: "02", // mainly fine
+    80: "50", // windy
+    81: "01", // dry
+    82: "50", // humid
+    83: "50", // fog
+    84: "50", // mist
+    85: "50", // haze
+    90: "01", // hot
+    91: "01", // warm
+    92: "02", // cool
+    93: "13", // cold
+}
+
+// HKO only uses icons 70 to 77 at night.
+func hkoNightOnly(code int) bool {
+    return code >= 70 && code <= 77
+}
+
+var (
+    iconCacheMu sync.Mutex
+    iconCache   = make(map[string]image.Image)
+)
+
+// IsNight reports whether t is between 6pm and 6am, when the night variant
+// of an icon should be shown.
+func IsNight(t time.Time) bool {
+    hour := t.Hour()
+    return hour >= 18 || hour < 6
+}
+
+// HKOIconName returns the bundled icon name, e.g. "icons/10d.png", for an HKO
+// weather icon number.
+func HKOIconName(code int, night bool) string {
+    base, ok := hkoIcons[code]
+    if !ok {
+        return "icons/" + FallbackIcon + ".png"
+    }
+
+    if night || hkoNightOnly(code) {
+        return "icons/" + base + "n.png"
+    }
+    return "icons/" + base + "d.png"
+}
+
+// HKOIcon returns the image for an HKO weather icon number. If the day or
+// night variant is missing the other one is used, then FallbackIcon, and if
+// even that can't be loaded, a grey ring drawn here, so there is always an
+// icon to show.
+func HKOIcon(code int, night bool) image.Image {
+    name := HKOIconName(code, night)
+
+    candidates := []string{name}
+    if base, ok := hkoIcons[code]; ok {
+        candidates = append(candidates,
+            "icons/"+base+"d.png",
+            "icons/"+base+"n.png",
+            "icons/"+FallbackIcon+".png")
+    }
+
+    for _, candidate := range candidates {
+        img, err := Icon(candidate)
+        if err == nil {
+            return img
+        }
+    }
+
+    return fallbackImage()
+}
+
+var (
+    fallbackOnce sync.Once
+    fallback     image.Image
+)
+
+// fallbackImage is a grey ring, for when not even FallbackIcon loads.
+func fallbackImage() image.Image {
+    fallbackOnce.Do(func() {
+        const size, outer, inner = 64, 30, 24
+        img := image.NewNRGBA(image.Rect(0, 0, size, size))
+        for y := 0; y < size; y++ {
+            for x := 0; x < size; x++ {
+                dx, dy := x-size/2, y-size/2
+                if d := dx*dx + dy*dy; d <= outer*outer && d >= inner*inner {
+                    img.Set(x, y, color.NRGBA{0x9a, 0xa0, 0xa8, 0xff})
+                }
+            }
+        }
+        fallback = img
+    })
+    return fallback
+}
+
+// Icon loads a PNG from the bundled icons, decoding each one only once.
+func Icon(name string) (image.Image, error) {
+    iconCacheMu.Lock()
+    defer iconCacheMu.Unlock()
+
+    if img, ok := iconCache[name]; ok {
+        return img, nil
+    }
+
+    file, err := assets.IconFS.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    img, err := png.Decode(file)
+    if err != nil {
+        return nil, fmt.Errorf("decoding %s: %w", name, err)
+    }
+
+    iconCache[name] = img
+    return img, nil
+}
+
+// DrawIcon scales icon to fit rect, keeping its aspect ratio, and draws it
+// centred over what is already on dst.
+func DrawIcon(dst draw.Image, rect image.Rectangle, icon image.Image) {
+    bounds := icon.Bounds()
+    w, h := rect.Dx(), bounds.Dy()*rect.Dx()/bounds.Dx()
+    if h > rect.Dy() {
+        w, h = bounds.Dx()*rect.Dy()/bounds.Dy(), rect.Dy()
+    }
+
+    x := rect.Min.X + (rect.Dx()-w)/2
+    y := rect.Min.Y + (rect.Dy()-h)/2
+    xdraw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), icon, bounds, xdraw.Over, nil)
+}
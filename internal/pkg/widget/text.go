@@ -0,0 +1,332 @@
+package widget
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "strings"
+    "sync"
+    "unicode"
+
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+
+    "github.com/tony-tsang/airmon/assets"
+)
+
+// Characters that may not start a line, and ones that may not end a line.
+const (
+    noBreakBefore = "，。、；：？！）」』》〉】〕,.;:!?)]}%…·"
+    noBreakAfter  = "（「『《〈【〔([{"
+)
+
+const ellipsis = "…"
+
+type Align int
+
+const (
+    AlignLeft Align = iota
+    AlignCenter
+    AlignRight
+)
+
+// TextBox describes where and how to draw a piece of text. Sizes are in
+// pixels. Text is shrunk a point at a time from Size down to MinSize until it
+// fits, and cut short with an ellipsis if it still doesn't. LineSpacing is a
+// multiple of the font's line height, 1 if unset.
+type TextBox struct {
+    Rect        image.Rectangle
+    Size        float64
+    MinSize     float64
+    Color       color.Color
+    Align       Align
+    LineSpacing float64
+}
+
+// Fonts parses a font once and keeps a face for every size asked for, so
+// refreshing the screen doesn't re-rasterise the font each time.
+type Fonts struct {
+    mu    sync.Mutex
+    font  *opentype.Font
+    faces map[float64]font.Face
+}
+
+var (
+    defaultFonts     *Fonts
+    defaultFontsErr  error
+    defaultFontsOnce sync.Once
+)
+
+// NewFonts parses an OpenType or TrueType font.
+func NewFonts(data []byte) (*Fonts, error) {
+    f, err := opentype.Parse(data)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Fonts{font: f, faces: make(map[float64]font.Face)}, nil
+}
+
+// DefaultFonts returns the bundled Noto Sans TC, which covers both Chinese
+// and Latin text.
+func DefaultFonts() (*Fonts, error) {
+    defaultFontsOnce.Do(func() {
+        defaultFonts, defaultFontsErr = NewFonts(assets.FontData)
+    })
+    return defaultFonts, defaultFontsErr
+}
+
+// Face returns the face for size. Faces are shared, so they must only be
+// used from one goroutine at a time.
+func (f *Fonts) Face(size float64) (font.Face, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    return f.face(size)
+}
+
+func (f *Fonts) face(size float64) (font.Face, error) {
+    if face, ok := f.faces[size]; ok {
+        return face, nil
+    }
+
+    face, err := opentype.NewFace(f.font, &opentype.FaceOptions{
+        Size:    size,
+        DPI:     72,
+        Hinting: font.HintingFull,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    f.faces[size] = face
+    return face, nil
+}
+
+// DrawText draws text into box on dst and returns the font size it ended up
+// using. Glyphs are drawn without anti-aliasing in box.Color, so they stay
+// sharp after the image is dithered.
+func (f *Fonts) DrawText(dst draw.Image, text string, box TextBox) (float64, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    size := box.Size
+    minSize := box.MinSize
+    if minSize <= 0 || minSize > size {
+        minSize = size
+    }
+
+    spacing := box.LineSpacing
+    if spacing <= 0 {
+        spacing = 1
+    }
+
+    width := box.Rect.Dx()
+
+    for {
+        face, err := f.face(size)
+        if err != nil {
+            return 0, err
+        }
+
+        lineHeight := face.Metrics().Height.Mul(fixed.Int26_6(spacing * 64))
+        maxLines := box.Rect.Dy() / lineHeight.Ceil()
+        if maxLines < 1 {
+            maxLines = 1
+        }
+
+        lines := Layout(face, text, width)
+
+        if len(lines) <= maxLines || size-1 < minSize {
+            if len(lines) > maxLines {
+                lines = lines[:maxLines]
+                lines[maxLines-1] = ellipsize(face, lines[maxLines-1], fixed.I(width))
+            }
+
+            drawLines(dst, face, lines, box, lineHeight)
+            return size, nil
+        }
+
+        size--
+    }
+}
+
+func drawLines(dst draw.Image, face font.Face, lines []string, box TextBox, lineHeight fixed.Int26_6) {
+
+    mask := image.NewAlpha(box.Rect)
+    drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+
+    ascent := face.Metrics().Ascent
+    width := fixed.I(box.Rect.Dx())
+
+    for i, line := range lines {
+        x := fixed.I(box.Rect.Min.X)
+        switch box.Align {
+        case AlignCenter:
+            x += (width - drawer.MeasureString(line)) / 2
+        case AlignRight:
+            x += width - drawer.MeasureString(line)
+        }
+
+        y := fixed.I(box.Rect.Min.Y) + ascent + lineHeight*fixed.Int26_6(i)
+        drawer.Dot = fixed.Point26_6{X: x, Y: y}
+        drawer.DrawString(line)
+    }
+
+    textColor := box.Color
+    if textColor == nil {
+        textColor = Black
+    }
+
+    // threshold the anti-aliased glyphs so only pure colours are drawn
+    bounds := mask.Bounds().Intersect(dst.Bounds())
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            if mask.AlphaAt(x, y).A >= 0x80 {
+                dst.Set(x, y, textColor)
+            }
+        }
+    }
+}
+
+// Layout breaks text into lines no wider than width pixels. Latin text is
+// broken at spaces, CJK text between any two characters, without starting a
+// line with closing punctuation or ending one with opening punctuation.
+// Newlines in text always start a new line.
+func Layout(face font.Face, text string, width int) []string {
+    var lines []string
+
+    for _, paragraph := range strings.Split(text, "\n") {
+        lines = append(lines, wrap(face, strings.TrimRight(paragraph, "\r"), fixed.I(width))...)
+    }
+
+    return lines
+}
+
+func wrap(face font.Face, text string, width fixed.Int26_6) []string {
+    var lines []string
+    line := ""
+
+    segments := segment(text)
+
+    for i := 0; i < len(segments); i++ {
+        seg := segments[i]
+        if line == "" {
+            seg = strings.TrimLeft(seg, " ")
+            if seg == "" {
+                continue
+            }
+        }
+
+        candidate := line + seg
+        if font.MeasureString(face, strings.TrimRight(candidate, " ")) <= width {
+            line = candidate
+            continue
+        }
+
+        if line != "" {
+            lines = append(lines, strings.TrimRight(line, " "))
+            line = ""
+            i--
+            continue
+        }
+
+        // a single word wider than the line has to be split anywhere
+        head, tail := splitToWidth(face, seg, width)
+        lines = append(lines, head)
+        segments[i] = tail
+        i--
+    }
+
+    if line != "" || len(lines) == 0 {
+        lines = append(lines, strings.TrimRight(line, " "))
+    }
+
+    return lines
+}
+
+// segment splits text into the smallest pieces that can't be broken apart.
+func segment(text string) []string {
+    var segments []string
+    var current []rune
+    glue := false
+
+    flush := func() {
+        if len(current) > 0 {
+            segments = append(segments, string(current))
+            current = nil
+        }
+    }
+
+    for _, r := range text {
+        switch {
+        case strings.ContainsRune(noBreakBefore, r):
+            if len(current) == 0 && len(segments) > 0 {
+                current = []rune(segments[len(segments)-1])
+                segments = segments[:len(segments)-1]
+            }
+            current = append(current, r)
+            if isCJK(r) {
+                flush()
+            }
+
+        case strings.ContainsRune(noBreakAfter, r):
+            if !glue {
+                flush()
+            }
+            current = append(current, r)
+            glue = true
+            continue
+
+        case unicode.IsSpace(r):
+            current = append(current, ' ')
+
+        case isCJK(r):
+            if !glue {
+                flush()
+            }
+            current = append(current, r)
+            flush()
+
+        default:
+            if !glue && len(current) > 0 && current[len(current)-1] == ' ' {
+                flush()
+            }
+            current = append(current, r)
+        }
+
+        glue = false
+    }
+
+    flush()
+    return segments
+}
+
+func splitToWidth(face font.Face, text string, width fixed.Int26_6) (string, string) {
+    runes := []rune(text)
+
+    n := 1
+    for n < len(runes) && font.MeasureString(face, string(runes[:n+1])) <= width {
+        n++
+    }
+
+    return string(runes[:n]), string(runes[n:])
+}
+
+func ellipsize(face font.Face, line string, width fixed.Int26_6) string {
+    runes := []rune(strings.TrimRight(line, " "))
+
+    for len(runes) > 0 && font.MeasureString(face, string(runes)+ellipsis) > width {
+        runes = runes[:len(runes)-1]
+    }
+
+    return strings.TrimRight(string(runes), " ") + ellipsis
+}
+
+func isCJK(r rune) bool {
+    if r >= 0x3000 && r <= 0x303F || r >= 0xFF00 && r <= 0xFFEF {
+        return true
+    }
+    return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,28 @@
+// Package widget draws the pieces of the e-paper screen: text, icons and
+// charts. Everything is drawn in the panel's pure colours so it stays crisp
+// when the finished image is dithered for the display.
+package widget
+
+import (
+    "image/color"
+
+    "github.com/tony-tsang/airmon/internal/pkg/uc8159"
+)
+
+// Color returns the pure RGB colour for a panel colour.
+func Color(c uc8159.Color) color.Color {
+    if int(c) >= len(uc8159.SaturatedPalette) {
+        return uc8159.SaturatedPalette[uc8159.WHITE]
+    }
+    return uc8159.SaturatedPalette[c]
+}
+
+var (
+    Black  = Color(uc8159.BLACK)
+    White  = Color(uc8159.WHITE)
+    Green  = Color(uc8159.GREEN)
+    Blue   = Color(uc8159.BLUE)
+    Red    = Color(uc8159.RED)
+    Yellow = Color(uc8159.YELLOW)
+    Orange = Color(uc8159.ORANGE)
+)